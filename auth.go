@@ -0,0 +1,227 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	// Name of the cookie used by the web UI to authenticate.
+	authCookieName = "goprocmgr_token"
+)
+
+// Roles of tokens, each role is allowed to do everything the roles
+// before it are allowed to do. A viewer can read the state and logs,
+// an operator can also start, stop and restart servers and an admin
+// can also change the configuration.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var roleLevels = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// An authenticated token and the role it has.
+type TokenIdentity struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+type identityContextKey struct{}
+
+// Generate a token for the API if there isn't any configured yet and
+// store it in the config file to share it with the CLI. Returns true if
+// the token was generated.
+func (serve *Serve) ensureToken() bool {
+	if len(serve.config.Settings.Token) > 0 {
+		serve.protectTokenFile()
+		return false
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		log.Fatalf("Failed to generate API token: %s", err)
+	}
+
+	serve.config.Settings.Token = token
+
+	if err := serve.config.Save(); err != nil {
+		log.Fatalf("Failed to store API token: %s", err)
+	}
+
+	log.Printf("Generated a new API token and stored it in %s\n", serve.config.configFileName)
+	serve.protectTokenFile()
+
+	return true
+}
+
+// Make sure the config file with the token is only readable by the
+// owner, config files of earlier versions were readable by the group.
+func (serve *Serve) protectTokenFile() {
+	info, err := os.Stat(serve.config.configFileName)
+	if err != nil || info.Mode().Perm()&0077 == 0 {
+		return
+	}
+
+	if err := os.Chmod(serve.config.configFileName, 0600); err != nil {
+		log.Printf("Failed to make %s only readable by its owner: %s\n", serve.config.configFileName, err)
+		return
+	}
+
+	log.Printf("Made %s only readable by its owner since it stores the API token\n", serve.config.configFileName)
+}
+
+// Log how to log in to the web UI. The token is only included in the
+// URL when it was just generated, to not leak it into logs on every
+// start.
+func (serve *Serve) logLoginURL(baseURL string, generatedToken bool) {
+	if generatedToken {
+		log.Printf("Log in to the web UI at %s/login?token=%s\n", baseURL, serve.config.Settings.Token)
+		return
+	}
+
+	log.Printf("Log in to the web UI at %s with the token in %s\n", baseURL, serve.config.configFileName)
+}
+
+// Generate a random token.
+func generateToken() (string, error) {
+	token := make([]byte, 32)
+
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+// Make sure all configured tokens have a known role.
+func (serve *Serve) validateTokens() {
+	for _, token := range serve.config.Settings.Tokens {
+		if _, ok := roleLevels[token.Role]; !ok {
+			log.Fatalf("Unknown role '%s' for token '%s', expected '%s', '%s' or '%s'", token.Role, token.Name, RoleViewer, RoleOperator, RoleAdmin)
+		}
+
+		if len(token.Token) == 0 {
+			log.Fatalf("Missing token for token '%s'", token.Name)
+		}
+	}
+}
+
+// Get the token a request is authenticated with, either from the
+// Authorization header or from the cookie set by the web UI.
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+
+	if cookie, err := r.Cookie(authCookieName); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+// Look up the identity of a token, the token in the settings is the
+// admin token while the other tokens have their configured roles.
+func (serve *Serve) tokenIdentity(token string) (TokenIdentity, bool) {
+	if len(token) == 0 {
+		return TokenIdentity{}, false
+	}
+
+	// The settings are replaced when the config is reloaded.
+	serve.runner.stateMutex.Lock()
+	defer serve.runner.stateMutex.Unlock()
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(serve.config.Settings.Token)) == 1 {
+		return TokenIdentity{Name: "owner", Role: RoleAdmin}, true
+	}
+
+	for _, configured := range serve.config.Settings.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(configured.Token)) == 1 {
+			return TokenIdentity{Name: configured.Name, Role: configured.Role}, true
+		}
+	}
+
+	return TokenIdentity{}, false
+}
+
+// Get the identity of an authenticated request.
+func requestIdentity(r *http.Request) TokenIdentity {
+	identity, _ := r.Context().Value(identityContextKey{}).(TokenIdentity)
+
+	return identity
+}
+
+// Middleware to require a valid token for all API endpoints and the
+// metrics, which includes the names and usage of all servers.
+func (serve *Serve) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity, ok := serve.tokenIdentity(requestToken(r))
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(ServeMessageResponse{Message: "Unauthorized"})
+
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityContextKey{}, identity)))
+	})
+}
+
+// Wrap a handler to require the token of the request to have at least
+// the given role.
+func (serve *Serve) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if roleLevels[requestIdentity(r).Role] < roleLevels[role] {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(ServeMessageResponse{Message: "Forbidden, requires the " + role + " role"})
+
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// Handler to log in to the web UI with a token, the token is passed
+// as a query parameter or a form value and stored in a cookie.
+func (serve *Serve) loginHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+
+	if _, ok := serve.tokenIdentity(token); !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Invalid token\n"))
+
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     authCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,246 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Names of environment variables that looks like secrets, they are left
+// out of bundles together with the variables marked as secret.
+var secretNamePattern = regexp.MustCompile(`(?i)(secret|passw(or)?d|token|api_?key|private_?key|credential|auth)`)
+
+// A bundle of servers to share with others, the directories of the
+// servers are relative to a base directory.
+type ConfigBundle struct {
+	Servers          map[string]ServerConfig `json:"servers"`
+	StrippedEnv      map[string][]string     `json:"stripped_env,omitempty"`
+	StrippedCommands map[string][]string     `json:"stripped_commands,omitempty"`
+}
+
+// Export servers to a bundle, all servers are exported if no names are
+// given. Directories inside the base directory are made relative to it,
+// it defaults to the common directory of the servers. Secret environment
+// variables are stripped and PATH is left out since it's specific to the
+// machine. Secrets in commands are masked and the fields of the commands
+// are listed as stripped.
+func (config *Config) ExportBundle(names []string, baseDir string) (ConfigBundle, error) {
+	bundle := ConfigBundle{
+		Servers:          make(map[string]ServerConfig),
+		StrippedEnv:      make(map[string][]string),
+		StrippedCommands: make(map[string][]string),
+	}
+
+	if len(names) == 0 {
+		for name := range config.Servers {
+			names = append(names, name)
+		}
+	}
+
+	var errs ValidationErrors
+	var directories []string
+
+	for _, name := range names {
+		server, ok := config.Servers[name]
+		if !ok {
+			errs.add("server", "unknown server '%s'", name)
+			continue
+		}
+
+		directories = append(directories, server.Directory)
+	}
+
+	if len(baseDir) == 0 {
+		baseDir = commonDirectory(directories)
+	} else if !filepath.IsAbs(baseDir) {
+		errs.add("base_dir", "has to be an absolute path")
+	}
+
+	if len(errs) > 0 {
+		return bundle, errs
+	}
+
+	for _, name := range names {
+		// The secrets are masked in the commands too, which leaves the
+		// commands unusable until the secrets are put back.
+		server := config.Servers[name].Redacted()
+
+		if fields := maskedCommandFields(config.Servers[name], server); len(fields) > 0 {
+			bundle.StrippedCommands[name] = fields
+		}
+
+		if relative, err := filepath.Rel(baseDir, server.Directory); err == nil && !strings.HasPrefix(relative, "..") {
+			server.Directory = relative
+		}
+
+		environment := make(map[string]string)
+
+		for key, value := range server.Environment {
+			if key == "PATH" {
+				continue
+			}
+
+			if server.isSecret(key) || secretNamePattern.MatchString(key) {
+				bundle.StrippedEnv[name] = append(bundle.StrippedEnv[name], key)
+				continue
+			}
+
+			environment[key] = value
+		}
+
+		sort.Strings(bundle.StrippedEnv[name])
+
+		server.Environment = environment
+		server.SecretEnv = nil
+		bundle.Servers[name] = server
+	}
+
+	return bundle, nil
+}
+
+// Get the fields with commands of a server that had secrets masked.
+func maskedCommandFields(server ServerConfig, redacted ServerConfig) []string {
+	var fields []string
+
+	if redacted.Command != server.Command {
+		fields = append(fields, "cmd")
+	}
+
+	if commandsDiffer(redacted.PreStart, server.PreStart) {
+		fields = append(fields, "pre_start")
+	}
+
+	if commandsDiffer(redacted.PostStop, server.PostStop) {
+		fields = append(fields, "post_stop")
+	}
+
+	return fields
+}
+
+// Check if any command of two lists of the same commands differs.
+func commandsDiffer(commands []string, others []string) bool {
+	for index := range commands {
+		if commands[index] != others[index] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get the names of the servers with stripped environment variables or
+// commands.
+func strippedServers(stripped map[string][]string) []string {
+	var names []string
+
+	for name := range stripped {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Get the deepest directory that contains all directories.
+func commonDirectory(directories []string) string {
+	if len(directories) == 0 {
+		return string(filepath.Separator)
+	}
+
+	common := filepath.Clean(directories[0])
+
+	for _, directory := range directories[1:] {
+		directory = filepath.Clean(directory)
+
+		for common != directory && !strings.HasPrefix(directory, common+string(filepath.Separator)) {
+			parent := filepath.Dir(common)
+			if parent == common {
+				break
+			}
+
+			common = parent
+		}
+	}
+
+	return common
+}
+
+// Import the servers of a bundle with relative directories resolved in
+// the base directory. The servers are only imported if none of them
+// conflicts with an existing server and all of them are valid, unless
+// overwrite is set existing servers are conflicts. Servers without PATH
+// get the PATH of this program. The names of the imported servers are
+// returned.
+func (config *Config) ImportBundle(bundle ConfigBundle, baseDir string, overwrite bool) ([]string, error) {
+	var errs ValidationErrors
+	var names []string
+
+	for name := range bundle.Servers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	if !filepath.IsAbs(baseDir) {
+		errs.add("base_dir", "has to be an absolute path")
+		return nil, errs
+	}
+
+	// Validate the servers together with the other imported servers to
+	// allow references to imported tasks.
+	candidate := *config
+	candidate.Servers = config.copyServers()
+	imported := make(map[string]ServerConfig)
+
+	for _, name := range names {
+		server := bundle.Servers[name]
+		server.Name = name
+
+		if len(server.Directory) > 0 && !filepath.IsAbs(server.Directory) {
+			server.Directory = filepath.Join(baseDir, server.Directory)
+		}
+
+		if server.Environment == nil {
+			server.Environment = make(map[string]string)
+		}
+
+		if _, ok := server.Environment["PATH"]; !ok {
+			server.Environment["PATH"] = os.Getenv("PATH")
+		}
+
+		if _, ok := config.Servers[name]; ok && !overwrite {
+			errs.add(name+".name", "server %s already exists", name)
+		} else if err := config.checkWritable(name); err != nil {
+			errs.add(name+".name", "%s", err.(ValidationErrors)[0].Message)
+		}
+
+		candidate.Servers[name] = server
+		imported[name] = server
+	}
+
+	for _, name := range names {
+		for _, err := range candidate.ValidateServer(imported[name]) {
+			errs.add(name+"."+err.Field, "%s", err.Message)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	previous := config.copyServers()
+
+	for name, server := range imported {
+		config.Servers[name] = server
+	}
+
+	if err := config.saveServers(previous); err != nil {
+		return nil, fmt.Errorf("failed to save config: %s", err)
+	}
+
+	return names, nil
+}
@@ -2,15 +2,20 @@ package main // import "github.com/etu/goprocmgr"
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/jedib0t/go-pretty/v6/table"
@@ -20,15 +25,125 @@ type Cli struct {
 	config *Config
 }
 
+// Check if the running instance is reached over TLS, TLS is only
+// used when listening to TCP.
+func (cli *Cli) useTLS() bool {
+	return cli.config.Settings.TLSEnabled && cli.config.Settings.ListenNetwork != "unix"
+}
+
+// Build the URL to the running instance for a scheme and path, when
+// using a unix socket the host part is just a placeholder.
+func (cli *Cli) url(scheme string, path string) string {
+	if cli.config.Settings.ListenNetwork == "unix" {
+		return fmt.Sprintf("%s://unix%s", scheme, path)
+	}
+
+	if cli.useTLS() {
+		scheme += "s"
+	}
+
+	return fmt.Sprintf("%s://%s:%d%s", scheme, cli.config.Settings.ListenAddress, cli.config.Settings.ListenPort, path)
+}
+
+// Connect to the running instance, this ignores the address when
+// using a unix socket.
+func (cli *Cli) dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	var dialer net.Dialer
+
+	if cli.config.Settings.ListenNetwork == "unix" {
+		conn, err := dialer.DialContext(ctx, "unix", cli.config.Settings.ListenSocket)
+		if err != nil {
+			return nil, err
+		}
+
+		// The token is sent over the socket, so make sure it's ours.
+		if err := checkSocketPeer(conn, cli.config.Settings.ListenSocket); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+
+	return dialer.DialContext(ctx, network, address)
+}
+
+// Perform an authenticated request to the API of the running instance.
+func (cli *Cli) request(method string, path string, body io.Reader) (*http.Response, error) {
+	transport := &http.Transport{DialContext: cli.dial}
+
+	if cli.useTLS() {
+		tlsConfig, err := cli.config.pinnedTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	client := &http.Client{Transport: transport}
+
+	req, err := http.NewRequest(method, cli.url("http", path), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	req.Header.Set("Authorization", "Bearer "+cli.config.Settings.Token)
+
+	return client.Do(req)
+}
+
+// Establish an authenticated websocket connection to the running instance.
+func (cli *Cli) dialWebsocket(path string) (*websocket.Conn, error) {
+	dialer := websocket.Dialer{
+		NetDialContext:   cli.dial,
+		HandshakeTimeout: websocket.DefaultDialer.HandshakeTimeout,
+	}
+
+	if cli.useTLS() {
+		tlsConfig, err := cli.config.pinnedTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		dialer.TLSClientConfig = tlsConfig
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+cli.config.Settings.Token)
+
+	conn, _, err := dialer.Dial(cli.url("ws", path), header)
+
+	return conn, err
+}
+
+// Convert the config file to another file in the format given by the
+// extension of the other file.
+func (cli *Cli) ConvertConfig(fileName string) {
+	if err := cli.config.Convert(fileName); err != nil {
+		log.Printf("Failed to convert config: %s\n", err)
+		os.Exit(1)
+	}
+
+	log.Printf("Converted %s to %s\n", cli.config.configFileName, fileName)
+
+	if cli.config.GuessFileName("") == fileName {
+		log.Printf("The new config file is used by default from now on, %s can be removed\n", cli.config.configFileName)
+	} else {
+		log.Printf("Use it with -config %s, %s is kept\n", fileName, cli.config.configFileName)
+	}
+}
+
 func (cli *Cli) List(format string) {
 	var state map[string]ServerConfig
 	var runningState ServerItemList
 
-	// Build URL based on config
-	requestUrl := fmt.Sprintf("http://%s:%d/api/config/server", cli.config.Settings.ListenAddress, cli.config.Settings.ListenPort)
-
 	// Do request to running instance of program
-	res, err := http.Get(requestUrl)
+	res, err := cli.request(http.MethodGet, "/api/config/server", nil)
 
 	if err != nil {
 		log.Printf("Failed to connect to running instance of program: %s\n", err)
@@ -49,11 +164,8 @@ func (cli *Cli) List(format string) {
 	// Parse the json
 	json.Unmarshal(body, &state)
 
-	// Build URL based on config
-	requestUrl = fmt.Sprintf("http://%s:%d/api/state", cli.config.Settings.ListenAddress, cli.config.Settings.ListenPort)
-
 	// Get the state
-	res, err = http.Get(requestUrl)
+	res, err = cli.request(http.MethodGet, "/api/state", nil)
 
 	if err != nil {
 		log.Printf("Failed to connect to running instance of program: %s\n", err)
@@ -119,10 +231,82 @@ func (cli *Cli) List(format string) {
 	}
 }
 
-func (cli *Cli) Add(command string) {
-	// Build URL based on config to post to
-	requestUrl := fmt.Sprintf("http://%s:%d/api/config/server", cli.config.Settings.ListenAddress, cli.config.Settings.ListenPort)
+func (cli *Cli) Top() {
+	for {
+		var runningState ServerItemList
+
+		// Get the state
+		res, err := cli.request(http.MethodGet, "/api/state", nil)
+
+		if err != nil {
+			log.Printf("Failed to connect to running instance of program: %s\n", err)
+			os.Exit(1)
+		}
+
+		// Validate status code
+		if res.StatusCode != http.StatusOK {
+			log.Printf("Unexpected status code when fetching state: %d\n", res.StatusCode)
+			os.Exit(2)
+		}
+
+		// Read the body content and parse the json
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		json.Unmarshal(body, &runningState)
+
+		// Extract names of running servers and sort them
+		var keys []string
+		for name, server := range runningState.Servers {
+			if server.IsRunning {
+				keys = append(keys, name)
+			}
+		}
+		sort.Strings(keys)
+
+		output := table.NewWriter()
+		output.AppendHeader(table.Row{"Name", "CPU", "Memory", "Threads", "Files", "Uptime"})
+
+		for _, key := range keys {
+			stats := runningState.Servers[key].Stats
+
+			if stats == nil {
+				output.AppendRow([]interface{}{key, "-", "-", "-", "-", "-"})
+				continue
+			}
+
+			output.AppendRow([]interface{}{
+				key,
+				fmt.Sprintf("%.1f%%", stats.CPUPercent),
+				formatBytes(stats.MemoryRSS),
+				stats.Threads,
+				stats.OpenFiles,
+				(time.Duration(stats.Uptime) * time.Second).String(),
+			})
+		}
+
+		// Clear the screen and render the table
+		fmt.Print("\033[H\033[2J")
+		fmt.Println(output.Render())
+
+		time.Sleep(statsSampleInterval)
+	}
+}
+
+// Format a number of bytes in a human readable way.
+func formatBytes(bytes uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	size := float64(bytes)
+	unit := 0
+
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
 
+func (cli *Cli) Add(command string, serverType string) {
 	directory, err := os.Getwd()
 
 	if err != nil {
@@ -139,6 +323,7 @@ func (cli *Cli) Add(command string) {
 	// Build a new server config
 	server := ServerConfig{
 		Name:      filepath.Base(directory),
+		Type:      serverType,
 		Command:   command,
 		Directory: directory,
 		UseDirenv: useDirenv,
@@ -147,12 +332,20 @@ func (cli *Cli) Add(command string) {
 		},
 	}
 
+	if cli.createServer(server) {
+		log.Println("Created")
+	}
+}
+
+// Create a server through the API, validation errors are printed field
+// by field.
+func (cli *Cli) createServer(server ServerConfig) bool {
 	// Encode the server config as bytes
 	body, _ := json.Marshal(server)
 
 	// Pass new buffer for request with URL to post.
 	// This will make a post request and will share the JSON data
-	res, err := http.Post(requestUrl, "application/json", bytes.NewBuffer(body))
+	res, err := cli.request(http.MethodPost, "/api/config/server", bytes.NewBuffer(body))
 
 	// An error is returned if something goes wrong
 	if err != nil {
@@ -164,38 +357,368 @@ func (cli *Cli) Add(command string) {
 	// Hence defer close. It will automatically take care of it.
 	defer res.Body.Close()
 
-	// Check response code, if New user is created then read response.
+	var response ServeMessageResponse
+	resbody, _ := io.ReadAll(res.Body)
+
+	// Check response code, if New user is created then print the
+	// warnings of the response.
 	if res.StatusCode == http.StatusCreated {
-		log.Println("Created")
+		json.Unmarshal(resbody, &response)
+
+		for _, warning := range response.Warnings {
+			log.Printf("Warning: %s\n", warning)
+		}
+
+		return true
+	}
+
+	// Parse the json, print the errors field by field if
+	// it's a validation error and the response otherwise.
+	if err := json.Unmarshal(resbody, &response); err != nil || len(response.Errors) == 0 {
+		log.Printf("Failed to create server %s with response: %s", server.Name, resbody)
+		return false
+	}
+
+	log.Printf("Failed to create server %s:\n", server.Name)
+
+	for _, validationError := range response.Errors {
+		log.Printf("  %s: %s\n", validationError.Field, validationError.Message)
+	}
+
+	return false
+}
+
+// Import the processes of a Procfile as servers, or track the Procfile
+// to keep the servers in sync with it when it's edited.
+func (cli *Cli) ImportProcfile(fileName string, track bool) {
+	if track {
+		cli.trackProcfile(fileName)
+		return
+	}
+
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		log.Printf("Failed to read Procfile: %s\n", err)
+		os.Exit(1)
+	}
+
+	servers, err := parseProcfile(fileName, content)
+	if err != nil {
+		log.Printf("Failed to parse Procfile %s: %s\n", fileName, err)
+		os.Exit(1)
+	}
+
+	var names []string
+	for name := range servers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	failed := false
+
+	for _, name := range names {
+		server := servers[name]
+		server.Environment = map[string]string{
+			"PATH": os.Getenv("PATH"),
+		}
+
+		if cli.createServer(server) {
+			log.Printf("Created %s\n", name)
+		} else {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(4)
+	}
+}
+
+// Import the services of a compose file as servers and print the fields
+// of the services that couldn't be mapped.
+func (cli *Cli) ImportCompose(fileName string) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		log.Printf("Failed to read compose file: %s\n", err)
+		os.Exit(1)
+	}
+
+	imports, err := parseCompose(fileName, content)
+	if err != nil {
+		log.Printf("Failed to parse compose file %s: %s\n", fileName, err)
+		os.Exit(1)
+	}
+
+	failed := false
+
+	for _, imported := range imports {
+		if len(imported.Server.Command) == 0 {
+			log.Printf("Skipped %s, the service has no command and runs the command of its image\n", imported.Service)
+			failed = true
+			continue
+		}
+
+		if cli.createServer(imported.Server) {
+			log.Printf("Created %s from %s\n", imported.Server.Name, imported.Service)
+		} else {
+			failed = true
+		}
+	}
+
+	for _, imported := range imports {
+		if len(imported.Unmapped) == 0 {
+			continue
+		}
+
+		log.Printf("Fields of %s that couldn't be mapped:\n", imported.Service)
+
+		for _, unmapped := range imported.Unmapped {
+			log.Printf("  %s\n", unmapped)
+		}
+	}
+
+	if failed {
+		os.Exit(4)
+	}
+}
+
+// Export servers to a bundle file, or to stdout if the file name is -.
+// The servers are given as a comma separated list, all servers are
+// exported if it's empty.
+func (cli *Cli) Export(fileName string, servers string, baseDir string) {
+	query := url.Values{}
+
+	for _, server := range strings.Split(servers, ",") {
+		if len(strings.TrimSpace(server)) > 0 {
+			query.Add("server", strings.TrimSpace(server))
+		}
+	}
+
+	if len(baseDir) > 0 {
+		absolute, err := filepath.Abs(baseDir)
+		if err != nil {
+			log.Printf("Failed to get base directory: %s\n", err)
+			os.Exit(1)
+		}
+
+		query.Set("base_dir", absolute)
+	}
+
+	res, err := cli.request(http.MethodGet, "/api/config/export?"+query.Encode(), nil)
+	if err != nil {
+		log.Printf("Failed to connect to running instance of program: %s\n", err)
+		os.Exit(1)
+	}
+
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+
+	var bundle ConfigBundle
+	if res.StatusCode != http.StatusOK || json.Unmarshal(body, &bundle) != nil {
+		log.Printf("Failed to export servers with response: %s", body)
+		os.Exit(4)
+	}
+
+	content, _ := json.MarshalIndent(bundle, "", "    ")
+	content = append(content, '\n')
+
+	if fileName == "-" {
+		os.Stdout.Write(content)
+	} else if err := os.WriteFile(fileName, content, 0600); err != nil {
+		log.Printf("Failed to write bundle: %s\n", err)
+		os.Exit(1)
 	} else {
-		var response map[string]string
-		resbody, _ := io.ReadAll(res.Body)
+		log.Printf("Exported %d servers to %s\n", len(bundle.Servers), fileName)
+	}
 
-		// Parse the json
-		json.Unmarshal(resbody, &response)
+	for _, name := range strippedServers(bundle.StrippedEnv) {
+		log.Printf("Stripped the environment %s of %s\n", strings.Join(bundle.StrippedEnv[name], ", "), name)
+	}
 
-		// The status is not Created. print the error.
-		log.Printf("Failed to create server with response: %s", resbody)
+	for _, name := range strippedServers(bundle.StrippedCommands) {
+		log.Printf("Stripped secrets from %s of %s, they're masked in the bundle\n", strings.Join(bundle.StrippedCommands[name], ", "), name)
 	}
 }
 
-func (cli *Cli) Remove(name string) {
-	// Build URL based on config to post to
-	requestUrl := fmt.Sprintf("http://%s:%d/api/config/server/%s", cli.config.Settings.ListenAddress, cli.config.Settings.ListenPort, name)
+// Import the servers of a bundle file with the relative directories of
+// the servers in the base directory.
+func (cli *Cli) Import(fileName string, baseDir string, overwrite bool) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		log.Printf("Failed to read bundle: %s\n", err)
+		os.Exit(1)
+	}
+
+	var bundle ConfigBundle
+	if err := json.Unmarshal(content, &bundle); err != nil {
+		log.Printf("Failed to parse bundle %s: %s\n", fileName, err)
+		os.Exit(1)
+	}
+
+	if len(baseDir) == 0 {
+		baseDir = "."
+	}
+
+	baseDir, err = filepath.Abs(baseDir)
+	if err != nil {
+		log.Printf("Failed to get base directory: %s\n", err)
+		os.Exit(1)
+	}
 
-	// Create client
-	client := &http.Client{}
+	// Use the PATH of the importing user for the servers.
+	for name, server := range bundle.Servers {
+		if server.Environment == nil {
+			server.Environment = make(map[string]string)
+		}
 
-	// Create request
-	req, err := http.NewRequest(http.MethodDelete, requestUrl, nil)
+		server.Environment["PATH"] = os.Getenv("PATH")
+		bundle.Servers[name] = server
+	}
 
+	query := url.Values{}
+	query.Set("base_dir", baseDir)
+	query.Set("overwrite", fmt.Sprint(overwrite))
+
+	body, _ := json.Marshal(bundle)
+
+	res, err := cli.request(http.MethodPost, "/api/config/import?"+query.Encode(), bytes.NewBuffer(body))
 	if err != nil {
-		log.Printf("Failed to create request: %s\n", err)
-		os.Exit(3)
+		log.Printf("Failed to connect to running instance of program: %s\n", err)
+		os.Exit(1)
+	}
+
+	defer res.Body.Close()
+
+	var response ConfigImportResponse
+	resbody, _ := io.ReadAll(res.Body)
+
+	if err := json.Unmarshal(resbody, &response); err != nil {
+		log.Printf("Failed to import bundle with response: %s", resbody)
+		os.Exit(4)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		log.Println(response.Message)
+
+		for _, validationError := range response.Errors {
+			log.Printf("  %s: %s\n", validationError.Field, validationError.Message)
+		}
+
+		os.Exit(4)
+	}
+
+	log.Printf("Imported %s\n", strings.Join(response.Imported, ", "))
+
+	for _, name := range strippedServers(bundle.StrippedEnv) {
+		log.Printf("Set the environment %s of %s, it was stripped from the bundle\n", strings.Join(bundle.StrippedEnv[name], ", "), name)
+	}
+
+	for _, name := range strippedServers(bundle.StrippedCommands) {
+		log.Printf("Put back the secrets in %s of %s, they were stripped from the bundle\n", strings.Join(bundle.StrippedCommands[name], ", "), name)
+	}
+}
+
+// Register the directory of a Procfile as a project to include the
+// Procfile in the config.
+func (cli *Cli) trackProcfile(fileName string) {
+	if filepath.Base(fileName) != procfileName {
+		log.Printf("Only files named %s can be tracked\n", procfileName)
+		os.Exit(1)
+	}
+
+	directory, err := filepath.Abs(filepath.Dir(fileName))
+	if err != nil {
+		log.Printf("Failed to get directory of Procfile: %s\n", err)
+		os.Exit(1)
+	}
+
+	body, _ := json.Marshal(map[string]string{"dir": directory})
+
+	res, err := cli.request(http.MethodPost, "/api/config/project", bytes.NewBuffer(body))
+	if err != nil {
+		log.Printf("Failed to connect to running instance of program: %s\n", err)
+		os.Exit(1)
+	}
+
+	defer res.Body.Close()
+
+	var response ConfigReloadResponse
+	resbody, _ := io.ReadAll(res.Body)
+
+	if res.StatusCode != http.StatusOK || json.Unmarshal(resbody, &response) != nil || response.Changes == nil {
+		log.Printf("Failed to track Procfile with response: %s", resbody)
+		os.Exit(4)
+	}
+
+	log.Printf("Tracking %s: %s\n", filepath.Join(directory, procfileName), response.Changes)
+}
+
+// Export the servers that runs in the directory of a Procfile to the
+// Procfile, or to stdout if the file name is -.
+func (cli *Cli) ExportProcfile(fileName string) {
+	var servers map[string]ServerConfig
+
+	directory := filepath.Dir(fileName)
+	if fileName == "-" {
+		directory = "."
+	}
+
+	directory, err := filepath.Abs(directory)
+	if err != nil {
+		log.Printf("Failed to get directory of Procfile: %s\n", err)
+		os.Exit(1)
+	}
+
+	res, err := cli.request(http.MethodGet, "/api/config/server", nil)
+	if err != nil {
+		log.Printf("Failed to connect to running instance of program: %s\n", err)
+		os.Exit(1)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		log.Printf("Unexpected status code when fetching active config: %d\n", res.StatusCode)
+		os.Exit(2)
+	}
+
+	body, _ := io.ReadAll(res.Body)
+	json.Unmarshal(body, &servers)
+
+	content, names := formatProcfile(directory, servers)
+	if len(names) == 0 {
+		log.Printf("There are no servers in %s to export\n", directory)
+		os.Exit(2)
+	}
+
+	// A Procfile only has the commands, warn about what's left out.
+	for _, name := range names {
+		for key := range servers[name].Environment {
+			if key != "PATH" {
+				log.Printf("The environment of %s isn't exported\n", name)
+				break
+			}
+		}
+	}
+
+	if fileName == "-" {
+		os.Stdout.Write(content)
+		return
 	}
 
+	if err := os.WriteFile(fileName, content, 0644); err != nil {
+		log.Printf("Failed to write Procfile: %s\n", err)
+		os.Exit(1)
+	}
+
+	log.Printf("Exported %d servers to %s\n", len(names), fileName)
+}
+
+func (cli *Cli) Remove(name string) {
 	// Perform request
-	res, err := client.Do(req)
+	res, err := cli.request(http.MethodDelete, "/api/config/server/"+url.PathEscape(name), nil)
 
 	if err != nil {
 		log.Printf("Failed to connect to running instance of program: %s\n", err)
@@ -220,12 +743,9 @@ func (cli *Cli) Remove(name string) {
 }
 
 func (cli *Cli) Start(name string) {
-	// Build URL based on config to post to
-	requestUrl := fmt.Sprintf("http://%s:%d/api/runner/%s", cli.config.Settings.ListenAddress, cli.config.Settings.ListenPort, name)
-
 	// Pass new buffer for request with URL to post.
 	// This will make a post request and will share the JSON data
-	res, err := http.Post(requestUrl, "application/json", nil)
+	res, err := cli.request(http.MethodPost, "/api/runner/"+url.PathEscape(name), nil)
 
 	// An error is returned if something goes wrong
 	if err != nil {
@@ -248,23 +768,33 @@ func (cli *Cli) Start(name string) {
 	}
 }
 
-func (cli *Cli) Stop(name string) {
-	// Build URL based on config to post to
-	requestUrl := fmt.Sprintf("http://%s:%d/api/runner/%s", cli.config.Settings.ListenAddress, cli.config.Settings.ListenPort, name)
+func (cli *Cli) Restart(name string) {
+	// Pass new buffer for request with URL to post.
+	res, err := cli.request(http.MethodPost, "/api/runner/"+url.PathEscape(name)+"/restart", nil)
 
-	// Create client
-	client := &http.Client{}
+	// An error is returned if something goes wrong
+	if err != nil {
+		log.Printf("Failed to connect to running instance of program: %s\n", err)
+		os.Exit(1)
+	}
 
-	// Create request
-	req, err := http.NewRequest(http.MethodDelete, requestUrl, nil)
+	defer res.Body.Close()
 
-	if err != nil {
-		log.Printf("Failed to create request: %s\n", err)
-		os.Exit(3)
+	if res.StatusCode == http.StatusCreated {
+		log.Println("Restarted")
+		os.Exit(0)
 	}
 
+	// Handle error
+	resbody, _ := io.ReadAll(res.Body)
+
+	log.Printf("Failed to restart server with response: %s", resbody)
+	os.Exit(4)
+}
+
+func (cli *Cli) Stop(name string) {
 	// Perform request
-	res, err := client.Do(req)
+	res, err := cli.request(http.MethodDelete, "/api/runner/"+url.PathEscape(name), nil)
 
 	if err != nil {
 		log.Printf("Failed to connect to running instance of program: %s\n", err)
@@ -293,11 +823,8 @@ func (cli *Cli) Stop(name string) {
 func (cli *Cli) Logs(name string) {
 	var currentOffset uint = 0
 
-	// Build URL to establish websocket connection
-	wsUrl := fmt.Sprintf("ws://%s:%d/api/ws", cli.config.Settings.ListenAddress, cli.config.Settings.ListenPort)
-
 	// Create a new websocket connection
-	conn, _, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+	conn, err := cli.dialWebsocket("/api/ws")
 	if err != nil {
 		log.Printf("Failed to establish websocket connection: %s\n", err)
 		os.Exit(1)
@@ -0,0 +1,306 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A service of a compose file imported as a server, with descriptions
+// of the fields of the service that couldn't be mapped.
+type composeImport struct {
+	Service  string
+	Server   ServerConfig
+	Unmapped []string
+}
+
+// The dependency condition of a service that has to run to completion
+// before the depending service is started.
+const composeConditionCompleted = "service_completed_successfully"
+
+// Parse the services of a compose file into servers that run natively
+// in the directory of the compose file. Services that others depends on
+// to complete are imported as tasks and are listed before the servers.
+// The commands are run through a shell to split the arguments and expand
+// variables such as $PORT.
+func parseCompose(fileName string, content []byte) ([]composeImport, error) {
+	var file struct {
+		Services map[string]map[string]any `yaml:"services"`
+	}
+
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s", err)
+	}
+
+	if len(file.Services) == 0 {
+		return nil, fmt.Errorf("there are no services")
+	}
+
+	directory, err := filepath.Abs(filepath.Dir(fileName))
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the services that has to complete before others are
+	// started, they are imported as tasks.
+	tasks := make(map[string]bool)
+	for _, service := range file.Services {
+		for dependency, condition := range composeDependencies(service["depends_on"]) {
+			if condition == composeConditionCompleted {
+				tasks[dependency] = true
+			}
+		}
+	}
+
+	var imports []composeImport
+
+	for service, fields := range file.Services {
+		imported := composeImport{
+			Service: service,
+			Server: ServerConfig{
+				Name:      projectServerName(directory, service),
+				Directory: directory,
+				UseShell:  true,
+				Environment: map[string]string{
+					"PATH": os.Getenv("PATH"),
+				},
+			},
+		}
+
+		if tasks[service] {
+			imported.Server.Type = ServerTypeTask
+		}
+
+		var keys []string
+		for key := range fields {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if message := imported.mapField(key, fields[key], directory, file.Services); len(message) > 0 {
+				imported.Unmapped = append(imported.Unmapped, fmt.Sprintf("%s: %s", key, message))
+			}
+		}
+
+		imports = append(imports, imported)
+	}
+
+	// Create the tasks first since servers refers to them.
+	sort.Slice(imports, func(i, j int) bool {
+		if imports[i].Server.IsTask() != imports[j].Server.IsTask() {
+			return imports[i].Server.IsTask()
+		}
+
+		return imports[i].Service < imports[j].Service
+	})
+
+	return imports, nil
+}
+
+// Map a field of a compose service to the server, a description of why
+// the field couldn't be mapped is returned if it couldn't be mapped.
+func (imported *composeImport) mapField(key string, value any, directory string, services map[string]map[string]any) string {
+	server := &imported.Server
+
+	switch key {
+	case "command", "entrypoint":
+		command, ok := composeCommand(value)
+		if !ok {
+			return "has an unknown format"
+		}
+
+		// The entrypoint is run with the command as arguments.
+		if key == "entrypoint" {
+			server.Command = strings.TrimSpace(command + " " + server.Command)
+		} else {
+			server.Command = strings.TrimSpace(server.Command + " " + command)
+		}
+
+	case "build":
+		// Run the service in the directory it's built from.
+		context, _ := value.(string)
+		if build, ok := value.(map[string]any); ok {
+			context, _ = build["context"].(string)
+		}
+
+		if len(context) > 0 && !strings.Contains(context, "://") {
+			server.Directory = filepath.Join(directory, context)
+		}
+
+	case "working_dir":
+		workingDir, _ := value.(string)
+
+		// Absolute paths are paths in the container.
+		if filepath.IsAbs(workingDir) {
+			return fmt.Sprintf("%s is a path in the container, using %s", workingDir, server.Directory)
+		}
+
+		server.Directory = filepath.Join(server.Directory, workingDir)
+
+	case "environment":
+		var unset []string
+
+		for name, variable := range composeEnvironment(value) {
+			if variable != nil {
+				server.Environment[name] = *variable
+			} else if hostValue, ok := os.LookupEnv(name); ok {
+				server.Environment[name] = hostValue
+			} else {
+				unset = append(unset, name)
+			}
+		}
+
+		if len(unset) > 0 {
+			sort.Strings(unset)
+			return fmt.Sprintf("%s should be passed from the environment but isn't set", strings.Join(unset, ", "))
+		}
+
+	case "depends_on":
+		var dependencies []string
+		var unmapped []string
+
+		for dependency, condition := range composeDependencies(value) {
+			if _, ok := services[dependency]; !ok {
+				unmapped = append(unmapped, fmt.Sprintf("%s is an unknown service", dependency))
+			} else if condition != composeConditionCompleted || server.IsTask() {
+				unmapped = append(unmapped, fmt.Sprintf("%s has to be started first", dependency))
+			} else {
+				dependencies = append(dependencies, projectServerName(directory, dependency))
+			}
+		}
+
+		sort.Strings(dependencies)
+		sort.Strings(unmapped)
+
+		server.PreStartTasks = dependencies
+
+		if len(unmapped) > 0 {
+			return strings.Join(unmapped, ", ")
+		}
+
+	case "ports":
+		ports, _ := value.([]any)
+
+		// The server gets a random port in PORT instead of a published
+		// port, which only works if the service listens to it.
+		message := "the server gets a random port in $PORT"
+		if len(ports) > 1 {
+			return message + ", only one port can be used"
+		}
+
+		if !strings.Contains(server.Command, "PORT") && len(server.Environment["PORT"]) == 0 {
+			return fmt.Sprintf("%s, make sure the service listens to it instead of %v", message, ports)
+		}
+
+	case "image":
+		return "runs natively instead of in the image"
+
+	default:
+		return "has no equivalent"
+	}
+
+	return ""
+}
+
+// Get the command of a compose service as a shell command line, it's
+// either a string or a list of arguments that are quoted for the shell.
+// A $$ is how compose escapes a $ that it shouldn't interpolate.
+func composeCommand(value any) (string, bool) {
+	unescape := strings.NewReplacer("$$", "$")
+
+	switch typed := value.(type) {
+	case string:
+		return unescape.Replace(typed), true
+
+	case []any:
+		var arguments []string
+
+		for _, argument := range typed {
+			arguments = append(arguments, shellQuote(unescape.Replace(fmt.Sprint(argument))))
+		}
+
+		return strings.Join(arguments, " "), true
+	}
+
+	return "", false
+}
+
+// Arguments that don't have to be quoted for the shell.
+var shellSafePattern = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// Quote an argument for the shell, unless it's safe as it is.
+func shellQuote(argument string) string {
+	if shellSafePattern.MatchString(argument) {
+		return argument
+	}
+
+	return "'" + strings.ReplaceAll(argument, "'", `'\''`) + "'"
+}
+
+// Get the environment of a compose service, which is either a map or a
+// list of NAME=value. Variables without a value are passed from the
+// environment running compose and are nil.
+func composeEnvironment(value any) map[string]*string {
+	environment := make(map[string]*string)
+
+	switch typed := value.(type) {
+	case map[string]any:
+		for name, variable := range typed {
+			if variable == nil {
+				environment[name] = nil
+				continue
+			}
+
+			formatted := fmt.Sprint(variable)
+			environment[name] = &formatted
+		}
+
+	case []any:
+		for _, item := range typed {
+			name, variable, found := strings.Cut(fmt.Sprint(item), "=")
+
+			if found {
+				environment[name] = &variable
+			} else {
+				environment[name] = nil
+			}
+		}
+	}
+
+	return environment
+}
+
+// Get the dependencies of a compose service with their conditions, which
+// is either a list of services or a map of services with conditions.
+func composeDependencies(value any) map[string]string {
+	dependencies := make(map[string]string)
+
+	switch typed := value.(type) {
+	case []any:
+		for _, dependency := range typed {
+			dependencies[fmt.Sprint(dependency)] = "service_started"
+		}
+
+	case map[string]any:
+		for dependency, options := range typed {
+			condition := "service_started"
+
+			if options, ok := options.(map[string]any); ok {
+				if value, ok := options["condition"].(string); ok {
+					condition = value
+				}
+			}
+
+			dependencies[dependency] = condition
+		}
+	}
+
+	return dependencies
+}
@@ -0,0 +1,161 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseCompose(t *testing.T) {
+	path := os.Getenv("PATH")
+
+	tests := []struct {
+		content  string
+		expected []composeImport
+		err      bool
+	}{
+		{
+			content: `
+services:
+  web:
+    command: ["npm", "run", "dev", "--", "--port=$$PORT"]
+    environment:
+      - NODE_ENV=development
+    ports: ["3000:3000"]
+`,
+			expected: []composeImport{{
+				Service: "web",
+				Server: ServerConfig{
+					Name:        "app-web",
+					Directory:   "/srv/app",
+					Command:     "npm run dev -- '--port=$PORT'",
+					UseShell:    true,
+					Environment: map[string]string{"PATH": path, "NODE_ENV": "development"},
+				},
+			}},
+		},
+		{
+			content: `
+services:
+  api:
+    build: ./api
+    entrypoint: ./entrypoint.sh
+    command: serve
+    depends_on:
+      migrate:
+        condition: service_completed_successfully
+      db:
+        condition: service_started
+  migrate:
+    build:
+      context: ./api
+    command: ./migrate up
+  db:
+    image: postgres
+`,
+			expected: []composeImport{
+				{
+					Service: "migrate",
+					Server: ServerConfig{
+						Name:        "app-migrate",
+						Type:        ServerTypeTask,
+						Directory:   "/srv/app/api",
+						Command:     "./migrate up",
+						UseShell:    true,
+						Environment: map[string]string{"PATH": path},
+					},
+				},
+				{
+					Service: "api",
+					Server: ServerConfig{
+						Name:          "app-api",
+						Directory:     "/srv/app/api",
+						Command:       "./entrypoint.sh serve",
+						UseShell:      true,
+						Environment:   map[string]string{"PATH": path},
+						PreStartTasks: []string{"app-migrate"},
+					},
+					Unmapped: []string{"depends_on: db has to be started first"},
+				},
+				{
+					Service: "db",
+					Server: ServerConfig{
+						Name:        "app-db",
+						Directory:   "/srv/app",
+						UseShell:    true,
+						Environment: map[string]string{"PATH": path},
+					},
+					Unmapped: []string{"image: runs natively instead of in the image"},
+				},
+			},
+		},
+		{
+			content: `
+services:
+  worker:
+    command: ./worker
+    working_dir: /app
+    restart: always
+    ports: ["8080:8080"]
+`,
+			expected: []composeImport{{
+				Service: "worker",
+				Server: ServerConfig{
+					Name:        "app-worker",
+					Directory:   "/srv/app",
+					Command:     "./worker",
+					UseShell:    true,
+					Environment: map[string]string{"PATH": path},
+				},
+				Unmapped: []string{
+					"ports: the server gets a random port in $PORT, make sure the service listens to it instead of [8080:8080]",
+					"restart: has no equivalent",
+					"working_dir: /app is a path in the container, using /srv/app",
+				},
+			}},
+		},
+		{content: "services: {}\n", err: true},
+		{content: "version: '3'\n", err: true},
+		{content: "services: [\n", err: true},
+	}
+
+	for _, test := range tests {
+		imports, err := parseCompose("/srv/app/compose.yaml", []byte(test.content))
+
+		if test.err {
+			if err == nil {
+				t.Errorf("parseCompose(%q) = %+v, expected an error", test.content, imports)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseCompose(%q) failed: %s", test.content, err)
+		} else if !reflect.DeepEqual(imports, test.expected) {
+			t.Errorf("parseCompose(%q) = %+v, expected %+v", test.content, imports, test.expected)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		argument string
+		expected string
+	}{
+		{argument: "run", expected: "run"},
+		{argument: "--port=8080", expected: "--port=8080"},
+		{argument: "./bin/server", expected: "./bin/server"},
+		{argument: "", expected: "''"},
+		{argument: "hello world", expected: "'hello world'"},
+		{argument: "$PORT", expected: "'$PORT'"},
+		{argument: "it's", expected: `'it'\''s'`},
+		{argument: "a;rm -rf /", expected: "'a;rm -rf /'"},
+	}
+
+	for _, test := range tests {
+		if quoted := shellQuote(test.argument); quoted != test.expected {
+			t.Errorf("shellQuote(%q) = %s, expected %s", test.argument, quoted, test.expected)
+		}
+	}
+}
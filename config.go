@@ -1,30 +1,86 @@
 package main // import "github.com/etu/goprocmgr"
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
 type Config struct {
 	configFileName string
+	loadedContent  []byte // The content of the file when it was last read or written
+	savedContent   []byte // The encoded config when it was last read or written, to only write it when changed
+
+	includes       []*configInclude          // Included config files
+	serverIncludes map[string]*configInclude // The included file of each server, servers of the config file are missing
 
 	Settings struct {
-		ListenAddress string `json:"listen_address"`
-		ListenPort    uint   `json:"listen_port"`
-		PortRangeMin  uint   `json:"port_range_min"`
-		PortRangeMax  uint   `json:"port_range_max"`
+		ListenAddress  string        `json:"listen_address"`
+		ListenPort     uint          `json:"listen_port"`
+		PortRangeMin   uint          `json:"port_range_min"`
+		PortRangeMax   uint          `json:"port_range_max"`
+		Token          string        `json:"token"`
+		ListenNetwork  string        `json:"listen_network"`
+		ListenSocket   string        `json:"listen_socket"`
+		TLSEnabled     bool          `json:"tls_enabled"`
+		TLSCertFile    string        `json:"tls_cert_file,omitempty"`
+		TLSKeyFile     string        `json:"tls_key_file,omitempty"`
+		AllowedOrigins []string      `json:"allowed_origins,omitempty"`
+		Tokens         []TokenConfig `json:"tokens,omitempty"`
+		IncludeDir     string        `json:"include_dir,omitempty"`
+		Projects       []string      `json:"projects,omitempty"`
 	} `json:"settings"`
 	Servers map[string]ServerConfig `json:"servers"`
 }
 
+// Additional tokens for the API with their roles, the token in the
+// settings always has the admin role.
+type TokenConfig struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Role  string `json:"role"`
+}
+
+// Server types, a server is expected to keep running until it's
+// stopped while a task is expected to run to completion.
+const (
+	ServerTypeServer = "server"
+	ServerTypeTask   = "task"
+)
+
 type ServerConfig struct {
-	Name        string            `json:"name"`
-	Directory   string            `json:"cwd"`
-	Command     string            `json:"cmd"`
-	UseDirenv   bool              `json:"use_direnv"`
-	Environment map[string]string `json:"env"`
+	Name          string            `json:"name"`
+	Type          string            `json:"type,omitempty"`
+	Directory     string            `json:"cwd"`
+	Command       string            `json:"cmd"`
+	UseDirenv     bool              `json:"use_direnv"`
+	UseShell      bool              `json:"use_shell,omitempty"`
+	Environment   map[string]string `json:"env"`
+	SecretEnv     []string          `json:"secret_env,omitempty"`
+	SecretsFile   string            `json:"secrets_file,omitempty"`
+	PreStartTasks []string          `json:"pre_start_tasks,omitempty"`
+	PreStart      []string          `json:"pre_start,omitempty"`
+	PostStop      []string          `json:"post_stop,omitempty"`
+	Watch         []string          `json:"watch,omitempty"`
+	WatchIgnore   []string          `json:"watch_ignore,omitempty"`
+	MemoryLimit   string            `json:"memory_limit,omitempty"`
+	CPULimit      float64           `json:"cpu_limit,omitempty"`
+	NoFile        uint64            `json:"nofile,omitempty"`
+	Nice          int               `json:"nice,omitempty"`
+}
+
+// Check if the server config describes a one-shot task.
+func (server ServerConfig) IsTask() bool {
+	return server.Type == ServerTypeTask
 }
 
 func (config *Config) Read(configFileName string) {
@@ -32,15 +88,7 @@ func (config *Config) Read(configFileName string) {
 	config.configFileName = configFileName
 
 	// Set up default config struct.
-	config.Settings.ListenAddress = "127.0.0.1"
-	config.Settings.ListenPort = 6969
-	config.Settings.PortRangeMin = 40000
-	config.Settings.PortRangeMax = 41000
-
-	// Init servers map
-	if config.Servers == nil {
-		config.Servers = make(map[string]ServerConfig)
-	}
+	config.setDefaults()
 
 	if _, err := os.Stat(config.configFileName); err == nil {
 		// Read the config file
@@ -53,46 +101,482 @@ func (config *Config) Read(configFileName string) {
 		}
 
 		// Parse config
-		json.Unmarshal(fileContent, &config)
+		if err := config.parse(fileContent); err != nil {
+			log.Fatalf("Invalid config file %s: %s", config.configFileName, err)
+		}
 	} else {
 		log.Printf("Using default values as config will store config in %s if any changes are made\n", config.configFileName)
 	}
+
+	if err := config.readIncludes(); err != nil {
+		log.Fatalf("Invalid included config file %s", err)
+	}
+
+	if config.loadedContent != nil {
+		config.savedContent, _ = config.encode(config.loadedContent)
+	}
 }
 
-func (config *Config) Save() {
-	log.Printf("Writing configuration file at %s\n", config.configFileName)
+func (config *Config) setDefaults() {
+	config.Settings.ListenAddress = "127.0.0.1"
+	config.Settings.ListenPort = 6969
+	config.Settings.PortRangeMin = 40000
+	config.Settings.PortRangeMax = 41000
+	config.Settings.ListenNetwork = "tcp"
+	config.Settings.ListenSocket = config.defaultSocketPath()
 
-	encodedFile, _ := json.MarshalIndent(config, "", "    ")
-	_ = os.WriteFile(config.configFileName, encodedFile, 0640)
+	// Init servers map
+	if config.Servers == nil {
+		config.Servers = make(map[string]ServerConfig)
+	}
 }
 
-func (config *Config) WriteServer(server ServerConfig) error {
-	if len(server.Name) == 0 {
-		return fmt.Errorf("server 'name' cannot be empty")
+// Parse and validate the content of a config file on top of the
+// current config.
+func (config *Config) parse(content []byte) error {
+	if err := config.decode(content); err != nil {
+		return err
+	}
+
+	if errs := config.ValidateSettings(); len(errs) > 0 {
+		return fmt.Errorf("invalid settings: %s", errs)
+	}
+
+	if config.Servers == nil {
+		config.Servers = make(map[string]ServerConfig)
+	}
+
+	// The name of a server is its key, it's left out of hand written
+	// config files.
+	for name, server := range config.Servers {
+		server.Name = name
+		config.Servers[name] = server
+	}
+
+	// Remember what was loaded to detect changes to the file.
+	config.loadedContent = content
+
+	return nil
+}
+
+// Save the config, the config file and the included files are only
+// written if their servers or settings have changed.
+func (config *Config) Save() error {
+	encodedFile, err := config.encode(config.loadedContent)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %s", err)
+	}
+
+	if !bytes.Equal(encodedFile, config.savedContent) {
+		if err := writeConfigFile(config.configFileName, encodedFile, false); err != nil {
+			return err
+		}
+
+		config.loadedContent = encodedFile
+		config.savedContent = encodedFile
+	}
+
+	return config.saveIncludes()
+}
+
+// Write a config file while holding a lock on it and keep the previous
+// version as a backup. Files in projects are written without the lock
+// and backup files to not leave extra files in the project.
+func writeConfigFile(fileName string, content []byte, project bool) error {
+	log.Printf("Writing configuration file at %s\n", fileName)
+
+	if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %s", err)
 	}
 
-	if len(server.Directory) == 0 {
-		return fmt.Errorf("server 'cwd' cannot be empty")
+	// Write to the file a symlinked config file points to, such as a file
+	// in a dotfiles repository, to not replace the symlink with a file.
+	if realFileName, err := filepath.EvalSymlinks(fileName); err == nil {
+		fileName = realFileName
 	}
 
-	if len(server.Command) == 0 {
-		return fmt.Errorf("server 'cmd' cannot be empty")
+	// Keep the permissions of existing files, such as files in projects
+	// that are shared with others.
+	perm := os.FileMode(0600)
+	if info, err := os.Stat(fileName); err == nil {
+		perm = info.Mode().Perm()
 	}
 
+	if !project {
+		// Lock against other instances writing the config at the same time.
+		unlock, err := lockFile(fileName + ".lock")
+		if err != nil {
+			return fmt.Errorf("failed to lock config file: %s", err)
+		}
+		defer unlock()
+
+		// Keep the previous version of the config as a backup.
+		if previous, err := os.ReadFile(fileName); err == nil {
+			if err := writeFileAtomic(fileName+".bak", previous, 0600); err != nil {
+				return fmt.Errorf("failed to write config backup: %s", err)
+			}
+		}
+	}
+
+	if err := writeFileAtomic(fileName, content, perm); err != nil {
+		return fmt.Errorf("failed to write config file: %s", err)
+	}
+
+	return nil
+}
+
+// Write a file by writing a temporary file next to it that is renamed
+// to replace the file, to never leave a partially written file behind.
+func writeFileAtomic(fileName string, content []byte, perm os.FileMode) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	// Clean up the temporary file if anything fails before the rename.
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.Write(content); err != nil {
+		tempFile.Close()
+		return err
+	}
+
+	if err := tempFile.Chmod(perm); err != nil {
+		tempFile.Close()
+		return err
+	}
+
+	if err := tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return err
+	}
+
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tempFile.Name(), fileName); err != nil {
+		return err
+	}
+
+	// Sync the directory to persist the rename, this isn't possible on
+	// all platforms so errors are ignored.
+	if dir, err := os.Open(filepath.Dir(fileName)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	return nil
+}
+
+func (config *Config) WriteServer(server ServerConfig) error {
+	if err := config.checkWritable(server.Name); err != nil {
+		return err
+	}
+
+	if errs := config.ValidateServer(server); len(errs) > 0 {
+		return errs
+	}
+
+	previous := config.copyServers()
+
 	// Store the sent server config to the config.
 	config.Servers[server.Name] = server
 
 	// Save the config to disk.
-	config.Save()
+	return config.saveServers(previous)
+}
+
+func (config *Config) DeleteServer(serverName string) error {
+	if _, ok := config.Servers[serverName]; !ok {
+		return nil
+	}
+
+	if err := config.checkDeletable(serverName); err != nil {
+		return err
+	}
+
+	previous := config.copyServers()
+
+	delete(config.Servers, serverName)
+
+	if err := config.saveServers(previous); err != nil {
+		return err
+	}
+
+	delete(config.serverIncludes, serverName)
+
+	return nil
+}
+
+// Check that a server can be deleted, servers that other servers refer
+// to can't be deleted since the other servers would fail to start.
+func (config *Config) checkDeletable(name string) error {
+	if err := config.checkWritable(name); err != nil {
+		return err
+	}
+
+	if dependents := config.dependentServers(name); len(dependents) > 0 {
+		return ValidationErrors{{Field: "name", Message: fmt.Sprintf("server %s is used by %s, remove the references to it first", name, strings.Join(dependents, ", "))}}
+	}
+
+	return nil
+}
+
+// Get the names of the servers that refer to a server, either as a
+// pre-start task or in their env values.
+func (config *Config) dependentServers(name string) []string {
+	var dependents []string
+
+	for otherName, other := range config.Servers {
+		if otherName != name && other.refersTo(name) {
+			dependents = append(dependents, otherName)
+		}
+	}
+
+	sort.Strings(dependents)
+
+	return dependents
+}
+
+// Check if a server refers to another server.
+func (server ServerConfig) refersTo(name string) bool {
+	for _, task := range server.PreStartTasks {
+		if task == name {
+			return true
+		}
+	}
+
+	for _, value := range server.Environment {
+		if envRefersTo(value, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Copy the servers to be able to restore them.
+func (config *Config) copyServers() map[string]ServerConfig {
+	servers := make(map[string]ServerConfig, len(config.Servers))
+
+	for name, server := range config.Servers {
+		servers[name] = server
+	}
+
+	return servers
+}
+
+// Save the config after changing the servers, if it fails the servers
+// are restored to keep the config in sync with the file.
+func (config *Config) saveServers(previous map[string]ServerConfig) error {
+	if err := config.Save(); err != nil {
+		config.Servers = previous
+		return err
+	}
+
+	return nil
+}
+
+// Rename a server, this also updates the pre-start tasks and the env
+// references of other servers that refers to the server.
+func (config *Config) RenameServer(oldName string, newName string) error {
+	server, ok := config.Servers[oldName]
+	if !ok {
+		return fmt.Errorf("unknown server %s", oldName)
+	}
+
+	if err := config.checkWritable(oldName); err != nil {
+		return err
+	}
+
+	if _, ok := config.Servers[newName]; ok {
+		return ValidationErrors{{Field: "name", Message: fmt.Sprintf("server %s already exists", newName)}}
+	}
+
+	server.Name = newName
+
+	if errs := config.ValidateServer(server); len(errs) > 0 {
+		return errs
+	}
+
+	previous := config.copyServers()
+
+	config.Servers[newName] = server
+	delete(config.Servers, oldName)
+
+	// Keep the server in the file it came from.
+	include, included := config.serverIncludes[oldName]
+	if included {
+		config.serverIncludes[newName] = include
+		delete(config.serverIncludes, oldName)
+	}
+
+	for name, other := range config.Servers {
+		var preStartTasks []string
+
+		for _, task := range other.PreStartTasks {
+			if task == oldName {
+				task = newName
+			}
+
+			preStartTasks = append(preStartTasks, task)
+		}
+
+		other.PreStartTasks = preStartTasks
+
+		// Update the references in the environment, to a new map
+		// to not change the previous servers.
+		if len(other.Environment) > 0 {
+			environment := make(map[string]string, len(other.Environment))
+
+			for key, value := range other.Environment {
+				environment[key] = renameEnvReferences(value, oldName, newName)
+			}
+
+			other.Environment = environment
+		}
+
+		config.Servers[name] = other
+	}
+
+	if err := config.saveServers(previous); err != nil {
+		if included {
+			config.serverIncludes[oldName] = include
+			delete(config.serverIncludes, newName)
+		}
+
+		return err
+	}
 
 	return nil
 }
 
-func (config *Config) DeleteServer(serverName string) {
-	if _, ok := config.Servers[serverName]; ok {
-		delete(config.Servers, serverName)
-		config.Save()
+// Random key for the ETags of server configs, generated on start.
+var etagKey = newETagKey()
+
+func newETagKey() []byte {
+	key := make([]byte, 32)
+
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("Failed to generate ETag key: %s", err)
 	}
+
+	return key
+}
+
+// Get an ETag of the server config to detect concurrent changes. The
+// ETag is keyed since it covers secret values, a plain hash could be
+// used to guess weak secrets by anyone who can read the rest of the
+// config.
+func (server ServerConfig) ETag() string {
+	encoded, _ := json.Marshal(server)
+
+	mac := hmac.New(sha256.New, etagKey)
+	mac.Write(encoded)
+
+	return `"` + hex.EncodeToString(mac.Sum(nil)[:16]) + `"`
+}
+
+// Check if two server configs are the same, empty and missing fields
+// are considered the same.
+func (server ServerConfig) Equal(other ServerConfig) bool {
+	if len(server.Environment) == 0 {
+		server.Environment = nil
+	}
+
+	if len(other.Environment) == 0 {
+		other.Environment = nil
+	}
+
+	if !server.IsTask() {
+		server.Type = ServerTypeServer
+	}
+
+	if !other.IsTask() {
+		other.Type = ServerTypeServer
+	}
+
+	return server.ETag() == other.ETag()
+}
+
+// Apply a JSON merge patch (RFC 7396) to a copy of the server config.
+// Objects such as env are merged recursively and fields set to null are
+// reset to their zero value, or removed from objects.
+func (server ServerConfig) Patch(patch []byte) (ServerConfig, error) {
+	changes, err := decodeJSONValue(patch)
+	if err != nil {
+		return server, err
+	}
+
+	if _, ok := changes.(map[string]any); !ok {
+		return server, fmt.Errorf("the patch has to be an object")
+	}
+
+	encoded, _ := json.Marshal(server)
+
+	fields, err := decodeJSONValue(encoded)
+	if err != nil {
+		return server, err
+	}
+
+	encoded, _ = json.Marshal(mergePatch(fields, changes))
+
+	var patched ServerConfig
+	if err := json.Unmarshal(encoded, &patched); err != nil {
+		return server, err
+	}
+
+	return patched, nil
+}
+
+// Decode a JSON value with numbers kept as they are written.
+func decodeJSONValue(content []byte) (any, error) {
+	var value any
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// Merge a JSON merge patch into a decoded JSON value.
+func mergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any)
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = mergePatch(targetObject[key], value)
+		}
+	}
+
+	return targetObject
+}
+
+// Get the default path of the unix socket to listen to, in the runtime
+// directory of the user if available. Otherwise it's in a directory of
+// the user in the temporary directory, which is created only accessible
+// by the user.
+func (config *Config) defaultSocketPath() string {
+	if os.Getenv("XDG_RUNTIME_DIR") != "" {
+		return filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "goprocmgr.sock")
+	}
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf("goprocmgr-%d", os.Getuid()), "goprocmgr.sock")
 }
 
 func (config *Config) GuessFileName(fileName string) string {
@@ -100,13 +584,21 @@ func (config *Config) GuessFileName(fileName string) string {
 		return fileName
 	}
 
+	directory := "/tmp"
+
 	if os.Getenv("XDG_CONFIG_DIR") != "" {
-		return os.Getenv("XDG_CONFIG_DIR") + "/goprocmgr.json"
+		directory = os.Getenv("XDG_CONFIG_DIR")
+	} else if os.Getenv("HOME") != "" {
+		directory = os.Getenv("HOME") + "/.config"
 	}
 
-	if os.Getenv("HOME") != "" {
-		return os.Getenv("HOME") + "/.config/goprocmgr.json"
+	// Use a config in any of the other formats if there is one, they
+	// are preferred since they can only exist if someone made them.
+	for _, extension := range []string{".yaml", ".yml", ".toml"} {
+		if _, err := os.Stat(directory + "/goprocmgr" + extension); err == nil {
+			return directory + "/goprocmgr" + extension
+		}
 	}
 
-	return "/tmp/goprocmgr.json"
+	return directory + "/goprocmgr.json"
 }
@@ -0,0 +1,268 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported config file formats.
+const (
+	ConfigFormatJSON = "json"
+	ConfigFormatYAML = "yaml"
+	ConfigFormatTOML = "toml"
+)
+
+// Get the format of a config file by its extension, JSON is used for
+// unknown extensions.
+func configFormat(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		return ConfigFormatYAML
+	case ".toml":
+		return ConfigFormatTOML
+	}
+
+	return ConfigFormatJSON
+}
+
+// Decode the content of the config file in the format of the file on
+// top of the current config.
+func (config *Config) decode(content []byte) error {
+	return decodeConfigFile(config.configFileName, content, config)
+}
+
+// Decode the content of a config file in the format of the file into
+// a value.
+func decodeConfigFile(fileName string, content []byte, value any) error {
+	var generic any
+
+	switch configFormat(fileName) {
+	case ConfigFormatYAML:
+		if err := yaml.Unmarshal(content, &generic); err != nil {
+			return fmt.Errorf("failed to parse %s", err)
+		}
+
+	case ConfigFormatTOML:
+		var table map[string]any
+		if err := toml.Unmarshal(content, &table); err != nil {
+			return fmt.Errorf("failed to parse %s", err)
+		}
+
+		generic = table
+
+	default:
+		if err := json.Unmarshal(content, value); err != nil {
+			return fmt.Errorf("failed to parse at %s", describeJSONError(content, err))
+		}
+
+		return nil
+	}
+
+	// The other formats are decoded through JSON to use the same field
+	// names as in JSON.
+	encoded, err := json.Marshal(generic)
+	if err != nil {
+		return fmt.Errorf("failed to parse: %s", err)
+	}
+
+	if err := json.Unmarshal(encoded, value); err != nil {
+		return fmt.Errorf("invalid value: %s", err)
+	}
+
+	return nil
+}
+
+// Write the config to another file, the format is chosen by the
+// extension of the other file.
+func (config *Config) Convert(fileName string) error {
+	if config.loadedContent == nil {
+		return fmt.Errorf("there is no config file at %s to convert", config.configFileName)
+	}
+
+	if _, err := os.Stat(fileName); err == nil {
+		return fmt.Errorf("%s already exists", fileName)
+	}
+
+	converted := *config
+	converted.configFileName = fileName
+	converted.loadedContent = nil
+	converted.savedContent = nil
+
+	return converted.Save()
+}
+
+// Encode the config in the format of the file, without the servers
+// that belongs to included files.
+func (config *Config) encode(previous []byte) ([]byte, error) {
+	own := *config
+	own.Servers = make(map[string]ServerConfig)
+
+	for name, server := range config.Servers {
+		if _, ok := config.serverIncludes[name]; !ok {
+			own.Servers[name] = server
+		}
+	}
+
+	return encodeConfigFile(config.configFileName, &own, previous)
+}
+
+// Encode a value in the format of a config file. When the file is YAML
+// the comments of the previous content are kept where possible.
+func encodeConfigFile(fileName string, value any, previous []byte) ([]byte, error) {
+	format := configFormat(fileName)
+
+	if format == ConfigFormatJSON {
+		return json.MarshalIndent(value, "", "    ")
+	}
+
+	generic, err := toGeneric(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == ConfigFormatTOML {
+		var buffer bytes.Buffer
+		if err := toml.NewEncoder(&buffer).Encode(generic); err != nil {
+			return nil, err
+		}
+
+		return buffer.Bytes(), nil
+	}
+
+	var root yaml.Node
+	if err := root.Encode(generic); err != nil {
+		return nil, err
+	}
+
+	document := yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{&root}}
+
+	var previousDocument yaml.Node
+	if err := yaml.Unmarshal(previous, &previousDocument); err == nil && len(previousDocument.Content) > 0 {
+		mergeYAMLComments(&previousDocument, &document)
+	}
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(&document); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), encoder.Close()
+}
+
+// Convert a value to maps, slices and scalars through its JSON encoding
+// to use the same field names in all formats. Null values are dropped
+// since TOML can't represent them.
+func toGeneric(value any) (any, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+
+	var generic any
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	return normalizeGeneric(generic), nil
+}
+
+func normalizeGeneric(value any) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, item := range typed {
+			if item == nil {
+				delete(typed, key)
+				continue
+			}
+
+			typed[key] = normalizeGeneric(item)
+		}
+
+	case []any:
+		for i, item := range typed {
+			typed[i] = normalizeGeneric(item)
+		}
+
+	case json.Number:
+		if integer, err := typed.Int64(); err == nil {
+			return integer
+		}
+
+		float, _ := typed.Float64()
+
+		return float
+	}
+
+	return value
+}
+
+// Copy the comments from a previous YAML node to a new node, keys of
+// mappings keep their previous order and new keys are added last.
+func mergeYAMLComments(previous *yaml.Node, node *yaml.Node) {
+	node.HeadComment = previous.HeadComment
+	node.LineComment = previous.LineComment
+	node.FootComment = previous.FootComment
+
+	if previous.Kind != node.Kind {
+		return
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for i := range node.Content {
+			if i < len(previous.Content) {
+				mergeYAMLComments(previous.Content[i], node.Content[i])
+			}
+		}
+
+	case yaml.MappingNode:
+		positions := make(map[string]int)
+		for i := 0; i+1 < len(previous.Content); i += 2 {
+			positions[previous.Content[i].Value] = i
+		}
+
+		type pair struct {
+			key      *yaml.Node
+			value    *yaml.Node
+			position int
+		}
+
+		var pairs []pair
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			position, ok := positions[key.Value]
+			if ok {
+				mergeYAMLComments(previous.Content[position], key)
+				mergeYAMLComments(previous.Content[position+1], value)
+			} else {
+				position = len(previous.Content) + i
+			}
+
+			pairs = append(pairs, pair{key: key, value: value, position: position})
+		}
+
+		sort.SliceStable(pairs, func(i, j int) bool {
+			return pairs[i].position < pairs[j].position
+		})
+
+		node.Content = node.Content[:0]
+		for _, p := range pairs {
+			node.Content = append(node.Content, p.key, p.value)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	// The examples of RFC 7396.
+	tests := []struct {
+		target   string
+		patch    string
+		expected string
+	}{
+		{target: `{"a":"b"}`, patch: `{"a":"c"}`, expected: `{"a":"c"}`},
+		{target: `{"a":"b"}`, patch: `{"b":"c"}`, expected: `{"a":"b","b":"c"}`},
+		{target: `{"a":"b"}`, patch: `{"a":null}`, expected: `{}`},
+		{target: `{"a":"b","b":"c"}`, patch: `{"a":null}`, expected: `{"b":"c"}`},
+		{target: `{"a":["b"]}`, patch: `{"a":"c"}`, expected: `{"a":"c"}`},
+		{target: `{"a":"c"}`, patch: `{"a":["b"]}`, expected: `{"a":["b"]}`},
+		{target: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, expected: `{"a":{"b":"d"}}`},
+		{target: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, expected: `{"a":[1]}`},
+		{target: `["a","b"]`, patch: `["c","d"]`, expected: `["c","d"]`},
+		{target: `{"a":"b"}`, patch: `["c"]`, expected: `["c"]`},
+		{target: `{"a":"foo"}`, patch: `null`, expected: `null`},
+		{target: `{"a":"foo"}`, patch: `"bar"`, expected: `"bar"`},
+		{target: `{"e":null}`, patch: `{"a":1}`, expected: `{"a":1,"e":null}`},
+		{target: `[1,2]`, patch: `{"a":"b","c":null}`, expected: `{"a":"b"}`},
+		{target: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, expected: `{"a":{"bb":{}}}`},
+	}
+
+	for _, test := range tests {
+		target, err := decodeJSONValue([]byte(test.target))
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %s", test.target, err)
+		}
+
+		patch, err := decodeJSONValue([]byte(test.patch))
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %s", test.patch, err)
+		}
+
+		merged, _ := json.Marshal(mergePatch(target, patch))
+
+		if string(merged) != test.expected {
+			t.Errorf("mergePatch(%s, %s) = %s, expected %s", test.target, test.patch, merged, test.expected)
+		}
+	}
+}
+
+func TestServerConfigPatch(t *testing.T) {
+	server := ServerConfig{
+		Name:        "api",
+		Directory:   "/srv/api",
+		Command:     "./api",
+		Environment: map[string]string{"DEBUG": "1", "HTTP_PORT": "8080"},
+		Watch:       []string{"*.go"},
+		CPULimit:    1.5,
+	}
+
+	tests := []struct {
+		patch    string
+		expected ServerConfig
+		err      bool
+	}{
+		{
+			patch: `{"cmd": "./api --verbose"}`,
+			expected: ServerConfig{
+				Name:        "api",
+				Directory:   "/srv/api",
+				Command:     "./api --verbose",
+				Environment: map[string]string{"DEBUG": "1", "HTTP_PORT": "8080"},
+				Watch:       []string{"*.go"},
+				CPULimit:    1.5,
+			},
+		},
+		{
+			patch: `{"env": {"DEBUG": null, "LOG_LEVEL": "info"}}`,
+			expected: ServerConfig{
+				Name:        "api",
+				Directory:   "/srv/api",
+				Command:     "./api",
+				Environment: map[string]string{"HTTP_PORT": "8080", "LOG_LEVEL": "info"},
+				Watch:       []string{"*.go"},
+				CPULimit:    1.5,
+			},
+		},
+		{
+			patch: `{"watch": null, "cpu_limit": null, "nice": 5}`,
+			expected: ServerConfig{
+				Name:        "api",
+				Directory:   "/srv/api",
+				Command:     "./api",
+				Environment: map[string]string{"DEBUG": "1", "HTTP_PORT": "8080"},
+				Nice:        5,
+			},
+		},
+		{patch: `["cmd"]`, err: true},
+		{patch: `{"cmd": `, err: true},
+		{patch: `{"nice": "high"}`, err: true},
+	}
+
+	for _, test := range tests {
+		patched, err := server.Patch([]byte(test.patch))
+
+		if test.err {
+			if err == nil {
+				t.Errorf("Patch(%s) = %+v, expected an error", test.patch, patched)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Patch(%s) failed: %s", test.patch, err)
+		} else if !reflect.DeepEqual(patched, test.expected) {
+			t.Errorf("Patch(%s) = %+v, expected %+v", test.patch, patched, test.expected)
+		}
+	}
+}
@@ -23,14 +23,30 @@ _goprocmgr() {
     cur="${COMP_WORDS[COMP_CWORD]}"
     prev="${COMP_WORDS[COMP_CWORD-1]}"
 
-    opts="-config -serve -list -list-format -add -remove -start -stop -logs -version"
+    opts="-config -config-convert -serve -list -list-format -add -add-type -remove -start -stop -restart -logs -import-procfile -track -export-procfile -import-compose -export -servers -import -base-dir -overwrite -top -version"
 
     # Case handling based on the previous word
     case "${prev}" in
+        -config|-config-convert|-import-procfile|-export-procfile|-import-compose|-export|-import)
+            mapfile -t COMPREPLY < <(compgen -f -- "${cur}")
+            return 0
+            ;;
+        -base-dir)
+            mapfile -t COMPREPLY < <(compgen -d -- "${cur}")
+            return 0
+            ;;
+        -servers)
+            mapfile -t COMPREPLY < <(compgen -W "$(__goprocmgr_get_names)" -- "${cur}")
+            return 0
+            ;;
         -list-format)
             mapfile -t COMPREPLY < <(compgen -W "table csv" -- "${cur}")
             return 0
             ;;
+        -add-type)
+            mapfile -t COMPREPLY < <(compgen -W "server task" -- "${cur}")
+            return 0
+            ;;
         -remove)
             mapfile -t COMPREPLY < <(compgen -W "$(__goprocmgr_get_names)" -- "${cur}")
             return 0
@@ -39,7 +55,7 @@ _goprocmgr() {
             mapfile -t COMPREPLY < <(compgen -W "$(__goprocmgr_get_stopped_names)" -- "${cur}")
             return 0
             ;;
-        -stop|-logs)
+        -stop|-restart|-logs)
             mapfile -t COMPREPLY < <(compgen -W "$(__goprocmgr_get_running_names)" -- "${cur}")
             return 0
             ;;
@@ -33,16 +33,29 @@ end
 
 # Set known action flags to be able to make completions not complete
 # two different actions at once.
-set -l actions '-serve -list -add -remove -start -stop -logs -version'
+set -l actions '-config-convert -serve -list -add -remove -start -stop -restart -logs -import-procfile -export-procfile -import-compose -export -import -top -version'
 
 # Complete main options
 complete --command goprocmgr --condition "not __fish_seen_subcommand_from -config"  --old-option config --require-parameter --force-files                                  --description 'Specify the configuration file'
 complete --command goprocmgr --condition '__fish_seen_subcommand_from -list'        --old-option list-format --exclusive           --arguments 'table csv'                 --description 'Specify the list format (table, csv)'
+complete --command goprocmgr --condition '__fish_seen_subcommand_from -add'         --old-option add-type --exclusive              --arguments 'server task'               --description 'Specify the type of server to add (server, task)'
+complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option config-convert --require-parameter --force-files                         --description 'Convert the config to the format of another file'
 complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option serve  --no-files                                                         --description 'Run the serve command (start the web server)'
 complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option list   --no-files                                                         --description 'List the stored servers'
 complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option add    --require-parameter                                                --description 'Add a new server'
 complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option remove --exclusive         --arguments '(__goprocmgr_get_names)'          --description 'Remove an existing server by its name'
 complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option start  --exclusive         --arguments '(__goprocmgr_get_stopped_names)'  --description 'Start an existing server by its name'
 complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option stop   --exclusive         --arguments '(__goprocmgr_get_running_names)'  --description 'Stop an existing server by its name'
+complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option restart --exclusive        --arguments '(__goprocmgr_get_running_names)'  --description 'Restart an existing server by its name'
 complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option logs   --exclusive         --arguments '(__goprocmgr_get_running_names)'  --description 'Tail the logs from an existing server by its name'
+complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option import-procfile --require-parameter --force-files                        --description 'Import the processes of a Procfile as servers'
+complete --command goprocmgr --condition '__fish_seen_subcommand_from -import-procfile' --old-option track --no-files                                                  --description 'Track the Procfile to keep the servers in sync with it'
+complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option export-procfile --require-parameter --force-files                        --description 'Export the servers of a directory to a Procfile'
+complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option import-compose --require-parameter --force-files                         --description 'Import the services of a compose file as servers'
+complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option export --require-parameter --force-files                                 --description 'Export servers to a bundle file'
+complete --command goprocmgr --condition '__fish_seen_subcommand_from -export'      --old-option servers --exclusive               --arguments '(__goprocmgr_get_names)'   --description 'Comma separated list of servers to export'
+complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option import --require-parameter --force-files                                 --description 'Import the servers of a bundle file'
+complete --command goprocmgr --condition '__fish_seen_subcommand_from -export -import' --old-option base-dir --exclusive        --arguments '(__fish_complete_directories)' --description 'Directory that the directories of servers are relative to'
+complete --command goprocmgr --condition '__fish_seen_subcommand_from -import'      --old-option overwrite --no-files                                                     --description 'Overwrite existing servers'
+complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option top    --no-files                                                         --description 'Show live resource usage of the running servers'
 complete --command goprocmgr --condition "not __fish_seen_subcommand_from $actions" --old-option version --no-files                                                        --description 'Print version'
@@ -0,0 +1,86 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// Header the web UI sends with requests that change state, since
+	// browsers don't allow other sites to set custom headers without a
+	// preflight request that isn't answered, it proves that the request
+	// comes from the web UI.
+	csrfHeaderName = "X-Goprocmgr-Csrf"
+)
+
+// Check if the origin of a request is allowed, requests without an
+// origin don't come from a web page so they're allowed.
+func (serve *Serve) isAllowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+
+	if len(origin) == 0 {
+		return true
+	}
+
+	// Allow the same origin as the host of the request.
+	if u, err := url.Parse(origin); err == nil && len(u.Host) > 0 && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	// The settings are replaced when the config is reloaded.
+	serve.runner.stateMutex.Lock()
+	defer serve.runner.stateMutex.Unlock()
+
+	for _, allowedOrigin := range serve.config.Settings.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowedOrigin, "/"), origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Middleware to reject cross-site requests, and requests that change
+// state without a token in the header or the custom CSRF header.
+func (serve *Serve) csrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !serve.isAllowedOrigin(r) {
+			writeForbidden(w, "Origin not allowed")
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/login" {
+			// Browsers tell if a request comes from another site, this
+			// covers requests where the origin isn't sent.
+			switch r.Header.Get("Sec-Fetch-Site") {
+			case "cross-site", "same-site":
+				if len(r.Header.Get("Origin")) == 0 {
+					writeForbidden(w, "Cross-site request not allowed")
+					return
+				}
+			}
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/api/") && !isSafeMethod(r.Method) {
+			if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") && len(r.Header.Get(csrfHeaderName)) == 0 {
+				writeForbidden(w, "Missing "+csrfHeaderName+" header")
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeForbidden(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(ServeMessageResponse{Message: message})
+}
+
+// Check if a request method doesn't change any state.
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
@@ -0,0 +1,180 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Shorthand references to the runtime values of other servers in env
+// values, such as ${api.PORT}.
+var envReferencePattern = regexp.MustCompile(`\$\{([A-Za-z0-9][A-Za-z0-9._-]*)\.(PORT)\}`)
+
+// Template actions in env values, such as {{ .Servers.api.Port }}.
+var envActionPattern = regexp.MustCompile(`\{\{.*?\}\}`)
+
+// Actions that refer to the data of env templates, other actions are
+// kept as they are since the value may be a template for another tool,
+// such as a format string of docker.
+var envTemplateDataPattern = regexp.MustCompile(`(^|[^\w.])\.(Servers|Port)\b`)
+
+// The data that env values are executed with as templates.
+type envTemplateData struct {
+	Port    uint // The port of the server that is started
+	Servers map[string]envTemplateServer
+}
+
+// A server that can be referred to in env templates.
+type envTemplateServer struct {
+	name   string
+	runner *Runner
+}
+
+// Get the port of a running server, to be used in templates.
+func (server envTemplateServer) Port() (uint, error) {
+	activeRunner, ok := server.runner.ActiveProcesses[server.name]
+	if !ok || activeRunner.hasExited() {
+		return 0, fmt.Errorf("server %s isn't running", server.name)
+	}
+
+	return activeRunner.Port, nil
+}
+
+// Resolve the references to runtime values of other servers in the env
+// values of a server that is started on a port.
+func (runner *Runner) resolveEnvironment(server ServerConfig, port uint) (map[string]string, error) {
+	data := envTemplateData{Port: port, Servers: make(map[string]envTemplateServer)}
+
+	for name := range runner.config.Servers {
+		data.Servers[name] = envTemplateServer{name: name, runner: runner}
+	}
+
+	var environment map[string]string
+	if server.Environment != nil {
+		environment = make(map[string]string, len(server.Environment))
+	}
+
+	for key, value := range server.Environment {
+		resolved, err := resolveEnvValue(value, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve env %s: %s", key, err)
+		}
+
+		environment[key] = resolved
+	}
+
+	return environment, nil
+}
+
+// Resolve the shorthand references in an env value and then execute the
+// template actions that refer to the servers or the port.
+func resolveEnvValue(value string, data envTemplateData) (string, error) {
+	var err error
+
+	value = envReferencePattern.ReplaceAllStringFunc(value, func(reference string) string {
+		match := envReferencePattern.FindStringSubmatch(reference)
+
+		server, ok := data.Servers[match[1]]
+		if !ok {
+			err = fmt.Errorf("unknown server %s", match[1])
+			return reference
+		}
+
+		port, portErr := server.Port()
+		if portErr != nil {
+			err = portErr
+		}
+
+		return fmt.Sprint(port)
+	})
+
+	if err != nil {
+		return value, err
+	}
+
+	value = envActionPattern.ReplaceAllStringFunc(value, func(action string) string {
+		if err != nil || !envTemplateDataPattern.MatchString(action) {
+			return action
+		}
+
+		tmpl, parseErr := template.New("env").Option("missingkey=error").Parse(action)
+		if parseErr != nil {
+			err = parseErr
+			return action
+		}
+
+		var resolved strings.Builder
+		if executeErr := tmpl.Execute(&resolved, data); executeErr != nil {
+			err = executeErr
+			return action
+		}
+
+		return resolved.String()
+	})
+
+	return value, err
+}
+
+// Validate the references to other servers in an env value, template
+// actions are only checked to parse since they are only known when
+// executed.
+func (config *Config) validateEnvValue(server ServerConfig, value string) error {
+	for _, match := range envReferencePattern.FindAllStringSubmatch(value, -1) {
+		if match[1] == server.Name {
+			return fmt.Errorf("refers to the server itself, use {{ .Port }} for its own port")
+		}
+
+		if _, ok := config.Servers[match[1]]; !ok {
+			return fmt.Errorf("refers to unknown server '%s'", match[1])
+		}
+	}
+
+	for _, action := range envActionPattern.FindAllString(value, -1) {
+		if !envTemplateDataPattern.MatchString(action) {
+			continue
+		}
+
+		if _, err := template.New("env").Parse(action); err != nil {
+			return fmt.Errorf("isn't a valid template: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// Pattern of the references to a server in template actions, either
+// as a field of .Servers or with index. Since names may contain dots
+// the name has to be followed by .Port or a character that can't be
+// part of a name, for .Servers.a.b.Port to not refer to a.
+func envTemplateReferencePattern(name string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(name)
+
+	return regexp.MustCompile(`(\.Servers\.)` + quoted + `(\.Port\b|[^A-Za-z0-9._-]|$)|(\.Servers ")` + quoted + `(")`)
+}
+
+// Check if an env value refers to a server, with the same references
+// that are replaced when renaming.
+func envRefersTo(value string, name string) bool {
+	for _, match := range envReferencePattern.FindAllStringSubmatch(value, -1) {
+		if match[1] == name {
+			return true
+		}
+	}
+
+	return envTemplateReferencePattern(name).MatchString(value)
+}
+
+// Replace the references to a server that is renamed in an env value.
+func renameEnvReferences(value string, oldName string, newName string) string {
+	value = envReferencePattern.ReplaceAllStringFunc(value, func(reference string) string {
+		match := envReferencePattern.FindStringSubmatch(reference)
+		if match[1] != oldName {
+			return reference
+		}
+
+		return "${" + newName + "." + match[2] + "}"
+	})
+
+	return envTemplateReferencePattern(oldName).ReplaceAllString(value, "${1}${3}"+newName+"${2}${4}")
+}
@@ -0,0 +1,99 @@
+package main // import "github.com/etu/goprocmgr"
+
+import "testing"
+
+func TestResolveEnvValue(t *testing.T) {
+	runner := &Runner{ActiveProcesses: map[string]*ActiveRunner{
+		"api":    {Port: 40001, Done: make(chan struct{})},
+		"my-api": {Port: 40002, Done: make(chan struct{})},
+	}}
+
+	data := envTemplateData{Port: 40000, Servers: map[string]envTemplateServer{
+		"api":     {name: "api", runner: runner},
+		"my-api":  {name: "my-api", runner: runner},
+		"stopped": {name: "stopped", runner: runner},
+	}}
+
+	tests := []struct {
+		value    string
+		expected string
+		err      bool
+	}{
+		{value: "plain", expected: "plain"},
+		{value: "http://localhost:${api.PORT}", expected: "http://localhost:40001"},
+		{value: "${my-api.PORT}", expected: "40002"},
+		{value: "{{ .Port }}", expected: "40000"},
+		{value: "{{ .Servers.api.Port }}", expected: "40001"},
+		{value: `{{ (index .Servers "my-api").Port }}`, expected: "40002"},
+		{value: "{{.ID}} {{ .Ports }}", expected: "{{.ID}} {{ .Ports }}"},
+		{value: "{{.ID}}:{{ .Port }}", expected: "{{.ID}}:40000"},
+		{value: "${unknown.PORT}", err: true},
+		{value: "${stopped.PORT}", err: true},
+		{value: "{{ .Servers.unknown.Port }}", err: true},
+	}
+
+	for _, test := range tests {
+		resolved, err := resolveEnvValue(test.value, data)
+
+		if test.err {
+			if err == nil {
+				t.Errorf("resolveEnvValue(%q) = %q, expected an error", test.value, resolved)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("resolveEnvValue(%q) failed: %s", test.value, err)
+		} else if resolved != test.expected {
+			t.Errorf("resolveEnvValue(%q) = %q, expected %q", test.value, resolved, test.expected)
+		}
+	}
+}
+
+func TestEnvRefersTo(t *testing.T) {
+	tests := []struct {
+		value    string
+		name     string
+		expected bool
+	}{
+		{value: "${a.PORT}", name: "a", expected: true},
+		{value: "${a.b.PORT}", name: "a", expected: false},
+		{value: "${a.b.PORT}", name: "a.b", expected: true},
+		{value: "${ab.PORT}", name: "a", expected: false},
+		{value: "{{ .Servers.a.Port }}", name: "a", expected: true},
+		{value: "{{ .Servers.a.b.Port }}", name: "a", expected: false},
+		{value: "{{ .Servers.ab.Port }}", name: "a", expected: false},
+		{value: "{{ with .Servers.a }}{{ .Port }}{{ end }}", name: "a", expected: true},
+		{value: `{{ (index .Servers "a.b").Port }}`, name: "a.b", expected: true},
+		{value: `{{ (index .Servers "a.b").Port }}`, name: "a", expected: false},
+		{value: "a", name: "a", expected: false},
+	}
+
+	for _, test := range tests {
+		if refers := envRefersTo(test.value, test.name); refers != test.expected {
+			t.Errorf("envRefersTo(%q, %q) = %t, expected %t", test.value, test.name, refers, test.expected)
+		}
+	}
+}
+
+func TestRenameEnvReferences(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{value: "${a.PORT}", expected: "${c.PORT}"},
+		{value: "${a.b.PORT} ${a.PORT}", expected: "${a.b.PORT} ${c.PORT}"},
+		{value: "{{ .Servers.a.Port }}", expected: "{{ .Servers.c.Port }}"},
+		{value: "{{ .Servers.a.b.Port }}", expected: "{{ .Servers.a.b.Port }}"},
+		{value: "{{ with .Servers.a }}{{ .Port }}{{ end }}", expected: "{{ with .Servers.c }}{{ .Port }}{{ end }}"},
+		{value: `{{ (index .Servers "a").Port }}`, expected: `{{ (index .Servers "c").Port }}`},
+		{value: `{{ (index .Servers "a.b").Port }}`, expected: `{{ (index .Servers "a.b").Port }}`},
+	}
+
+	for _, test := range tests {
+		if renamed := renameEnvReferences(test.value, "a", "c"); renamed != test.expected {
+			t.Errorf("renameEnvReferences(%q) = %q, expected %q", test.value, renamed, test.expected)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main // import "github.com/etu/goprocmgr"
+
+// File locking isn't supported on this platform, so writes aren't
+// protected against other instances writing at the same time.
+func lockFile(lockPath string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"os"
+	"syscall"
+)
+
+// Take an exclusive lock on a lock file, waiting for other instances
+// to release it. The returned function releases the lock.
+func lockFile(lockPath string) (func(), error) {
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
@@ -43,7 +43,7 @@
 
           src = ./.;
 
-          vendorHash = "sha256-aA+FeMBLhvh4pg3W4eHEfBtOf5oUnDDUkKnHEQA/+vI=";
+          vendorHash = "sha256-2cAVc2bI9XgS6QbhkubwjdZQ5zc4hj1fDrEDj4jaTFM=";
         });
       };
 
@@ -78,6 +78,9 @@
               wantedBy = ["multi-user.target"];
               serviceConfig.ExecStart = "${self.packages.${system}.default}/bin/${self.packages.${system}.default.pname}";
               serviceConfig.Restart = "always";
+
+              # Share the config file with the CLI to share the API token.
+              environment.HOME = "/root";
             };
           };
 
@@ -0,0 +1,260 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Names of the config file of a project, the first one that exists in
+// the project directory is used.
+var projectConfigFileNames = []string{"goprocmgr.json", "goprocmgr.yaml", "goprocmgr.yml", "goprocmgr.toml"}
+
+// A config file with servers that is included by the config file.
+type configInclude struct {
+	fileName      string
+	projectDir    string // Directory of the project for files in projects, relative directories of servers are relative to it
+	procfile      bool   // Procfiles are only read, they can't hold all fields of servers
+	loadedContent []byte // The content of the file when it was last read or written
+	savedContent  []byte // The encoded servers when they were last read or written, to only write the file when changed
+}
+
+// The content of an included config file.
+type includeFile struct {
+	Servers map[string]ServerConfig `json:"servers"`
+}
+
+// Get the include directory, it defaults to a directory next to the
+// config file named as the config file with .d as extension.
+func (config *Config) includeDir() string {
+	if len(config.Settings.IncludeDir) > 0 {
+		return config.Settings.IncludeDir
+	}
+
+	return strings.TrimSuffix(config.configFileName, filepath.Ext(config.configFileName)) + ".d"
+}
+
+// Find the files to include, the files of the include directory in
+// alphabetical order followed by the files of the projects. A Procfile
+// of a project is included in addition to its config file.
+func (config *Config) findIncludes() []*configInclude {
+	var includes []*configInclude
+
+	entries, _ := os.ReadDir(config.includeDir())
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".json", ".yaml", ".yml", ".toml":
+			if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				includes = append(includes, &configInclude{fileName: filepath.Join(config.includeDir(), entry.Name())})
+			}
+		}
+	}
+
+	for _, projectDir := range config.Settings.Projects {
+		for _, name := range projectConfigFileNames {
+			fileName := filepath.Join(projectDir, name)
+
+			if _, err := os.Stat(fileName); err == nil {
+				includes = append(includes, &configInclude{fileName: fileName, projectDir: projectDir})
+				break
+			}
+		}
+
+		fileName := filepath.Join(projectDir, procfileName)
+		if _, err := os.Stat(fileName); err == nil {
+			includes = append(includes, &configInclude{fileName: fileName, projectDir: projectDir, procfile: true})
+		}
+	}
+
+	return includes
+}
+
+// Read the included files and add their servers to the config, a server
+// can only be defined once in all files.
+func (config *Config) readIncludes() error {
+	config.includes = nil
+	config.serverIncludes = make(map[string]*configInclude)
+
+	for _, include := range config.findIncludes() {
+		content, err := os.ReadFile(include.fileName)
+		if err != nil {
+			return err
+		}
+
+		servers, err := include.decode(content)
+		if err != nil {
+			return fmt.Errorf("%s: %s", include.fileName, err)
+		}
+
+		// Servers of Procfiles are named by the directory, which may be
+		// the same for different projects.
+		if include.procfile {
+			servers = qualifyProcfileServers(filepath.Dir(include.fileName), servers, func(name string) bool {
+				_, ok := config.Servers[name]
+				return ok
+			})
+		}
+
+		for name, server := range servers {
+			if _, ok := config.Servers[name]; ok {
+				return fmt.Errorf("%s: server %s is already defined in %s", include.fileName, name, config.ServerFileName(name))
+			}
+
+			server.Name = name
+
+			if len(include.projectDir) > 0 && !filepath.IsAbs(server.Directory) {
+				server.Directory = filepath.Join(include.projectDir, server.Directory)
+			}
+
+			config.Servers[name] = server
+			config.serverIncludes[name] = include
+		}
+
+		log.Printf("Parsed included config file: %s\n", include.fileName)
+
+		include.loadedContent = content
+		include.savedContent, _ = config.encodeInclude(include)
+
+		config.includes = append(config.includes, include)
+	}
+
+	return nil
+}
+
+// Decode the servers of an included file.
+func (include *configInclude) decode(content []byte) (map[string]ServerConfig, error) {
+	if include.procfile {
+		return parseProcfile(include.fileName, content)
+	}
+
+	var file includeFile
+	if err := decodeConfigFile(include.fileName, content, &file); err != nil {
+		return nil, err
+	}
+
+	return file.Servers, nil
+}
+
+// Encode the servers of an included file in the format of the file.
+// Directories of servers in projects are stored relative to the project
+// to be the same for everyone working on it.
+func (config *Config) encodeInclude(include *configInclude) ([]byte, error) {
+	file := includeFile{Servers: make(map[string]ServerConfig)}
+
+	for name, server := range config.Servers {
+		if config.serverIncludes[name] != include {
+			continue
+		}
+
+		if len(include.projectDir) > 0 {
+			if relative, err := filepath.Rel(include.projectDir, server.Directory); err == nil && !strings.HasPrefix(relative, "..") {
+				server.Directory = relative
+			}
+		}
+
+		file.Servers[name] = server
+	}
+
+	return encodeConfigFile(include.fileName, &file, include.loadedContent)
+}
+
+// Write the included files that have changed servers.
+func (config *Config) saveIncludes() error {
+	for _, include := range config.includes {
+		if include.procfile {
+			continue
+		}
+
+		encoded, err := config.encodeInclude(include)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %s", include.fileName, err)
+		}
+
+		if bytes.Equal(encoded, include.savedContent) {
+			continue
+		}
+
+		if err := writeConfigFile(include.fileName, encoded, len(include.projectDir) > 0); err != nil {
+			return err
+		}
+
+		include.loadedContent = encoded
+		include.savedContent = encoded
+	}
+
+	return nil
+}
+
+// Check if any included file has been changed, added or removed since
+// they were last read or written by us. The names and content of the
+// current files are returned.
+func (config *Config) includesChanged() ([]byte, bool) {
+	var content []byte
+	changed := false
+
+	includes := config.findIncludes()
+	if len(includes) != len(config.includes) {
+		changed = true
+	}
+
+	for i, include := range includes {
+		fileContent, _ := os.ReadFile(include.fileName)
+		content = append(content, include.fileName...)
+		content = append(content, fileContent...)
+
+		if i >= len(config.includes) || config.includes[i].fileName != include.fileName || !bytes.Equal(config.includes[i].loadedContent, fileContent) {
+			changed = true
+		}
+	}
+
+	return content, changed
+}
+
+// Register a project directory to include its config file and Procfile.
+func (config *Config) AddProject(directory string) error {
+	if !filepath.IsAbs(directory) {
+		return ValidationErrors{{Field: "dir", Message: "has to be an absolute path"}}
+	}
+
+	if info, err := os.Stat(directory); err != nil || !info.IsDir() {
+		return ValidationErrors{{Field: "dir", Message: "isn't a directory"}}
+	}
+
+	for _, project := range config.Settings.Projects {
+		if project == directory {
+			return nil
+		}
+	}
+
+	previous := config.Settings.Projects
+	config.Settings.Projects = append(config.Settings.Projects, directory)
+
+	if err := config.Save(); err != nil {
+		config.Settings.Projects = previous
+		return err
+	}
+
+	return nil
+}
+
+// Check that a server can be changed, servers of Procfiles can only be
+// changed by editing the Procfile.
+func (config *Config) checkWritable(name string) error {
+	if include, ok := config.serverIncludes[name]; ok && include.procfile {
+		return ValidationErrors{{Field: "name", Message: fmt.Sprintf("server %s is defined in %s and can only be changed there", name, include.fileName)}}
+	}
+
+	return nil
+}
+
+// Get the file that a server is defined in.
+func (config *Config) ServerFileName(name string) string {
+	if include, ok := config.serverIncludes[name]; ok {
+		return include.fileName
+	}
+
+	return config.configFileName
+}
@@ -0,0 +1,67 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Check if the server config has any resource limits configured.
+func (server ServerConfig) HasLimits() bool {
+	return len(server.MemoryLimit) > 0 || server.CPULimit > 0 || server.NoFile > 0 || server.Nice != 0
+}
+
+// Validate the resource limits of a server config.
+func (server ServerConfig) validateLimits() ValidationErrors {
+	var errs ValidationErrors
+
+	if len(server.MemoryLimit) > 0 {
+		if _, err := parseByteSize(server.MemoryLimit); err != nil {
+			errs.add("memory_limit", "is invalid: %s", err)
+		}
+	}
+
+	if server.CPULimit < 0 {
+		errs.add("cpu_limit", "cannot be negative")
+	}
+
+	if server.Nice < -20 || server.Nice > 19 {
+		errs.add("nice", "has to be between -20 and 19")
+	}
+
+	return errs
+}
+
+// Parse a size in bytes with an optional K, M, G or T suffix using
+// powers of 1024, such as "512M" or "2G".
+func parseByteSize(value string) (uint64, error) {
+	multipliers := map[string]uint64{
+		"K": 1 << 10,
+		"M": 1 << 20,
+		"G": 1 << 30,
+		"T": 1 << 40,
+	}
+
+	value = strings.ToUpper(strings.TrimSpace(value))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+	multiplier := uint64(1)
+
+	if len(value) > 0 {
+		if suffixMultiplier, ok := multipliers[value[len(value)-1:]]; ok {
+			multiplier = suffixMultiplier
+			value = value[:len(value)-1]
+		}
+	}
+
+	size, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || size == 0 {
+		return 0, fmt.Errorf("expected a size such as 512M or 2G")
+	}
+
+	if size > math.MaxUint64/multiplier {
+		return 0, fmt.Errorf("the size is too large")
+	}
+
+	return size * multiplier, nil
+}
@@ -0,0 +1,210 @@
+//go:build linux
+
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	cgroupMountPoint = "/sys/fs/cgroup"
+	cgroupCPUPeriod  = 100000
+)
+
+// Apply the resource limits of a server to a command before it's
+// started. Memory and CPU limits are applied using a cgroup v2 if
+// available and delegated to us, otherwise memory falls back to a
+// rlimit. The command is wrapped in a shell that moves itself into the
+// cgroup and sets the rlimits and nice level before it replaces itself
+// with the command, so the limits also apply to all of its children.
+func (runner *Runner) applyLimits(activeRunner *ActiveRunner, name string, server ServerConfig, cmd *exec.Cmd) {
+	// Leave commands that can't be started as they are to not hide
+	// why they can't be started.
+	if cmd.Err != nil {
+		return
+	}
+
+	var script []string
+
+	if len(server.MemoryLimit) > 0 || server.CPULimit > 0 {
+		cgroupPath, err := createCgroup(name, server)
+
+		if err == nil {
+			activeRunner.cgroupPath = cgroupPath
+			script = append(script, fmt.Sprintf(
+				`{ echo $$ > %s; } 2>/dev/null || { echo "Failed to move the process into the cgroup for limits" >&2; exit 1; }`,
+				shellQuote(filepath.Join(cgroupPath, "cgroup.procs")),
+			))
+		} else {
+			activeRunner.appendLog("system", fmt.Sprintf("Failed to set up cgroup for limits, falling back to rlimits: %s", err))
+
+			if server.CPULimit > 0 {
+				activeRunner.appendLog("system", "The cpu_limit can only be applied using cgroup v2")
+			}
+
+			if len(server.MemoryLimit) > 0 {
+				// The ulimit of the data segment is in KiB.
+				memoryLimit, _ := parseByteSize(server.MemoryLimit)
+				script = append(script, rlimitScript("memory_limit", "-d", (memoryLimit+1023)/1024))
+				activeRunner.memoryRlimit = true
+			}
+		}
+	}
+
+	if server.NoFile > 0 {
+		script = append(script, rlimitScript("nofile", "-n", server.NoFile))
+	}
+
+	command := `exec "$0" "$@"`
+	if server.Nice != 0 {
+		command = fmt.Sprintf(`exec nice -n %d "$0" "$@"`, server.Nice)
+	}
+
+	wrapper := exec.Command("sh", append([]string{"-c", strings.Join(append(script, command), "\n"), cmd.Path}, cmd.Args[1:]...)...)
+	if wrapper.Err != nil {
+		activeRunner.appendLog("system", fmt.Sprintf("Failed to apply limits: %s", wrapper.Err))
+		return
+	}
+
+	cmd.Path = wrapper.Path
+	cmd.Args = wrapper.Args
+}
+
+// Shell command to set a rlimit, both the soft and hard limit is set. A
+// failure is logged and the command is started without the limit.
+func rlimitScript(setting string, flag string, limit uint64) string {
+	return fmt.Sprintf(`ulimit %s %d || echo "Failed to apply %s" >&2`, flag, limit, setting)
+}
+
+// Check if the exited process was killed by a limit and clean up the
+// cgroup of the process. Processes that were asked to stop are only
+// checked for the cgroup, since stopping them may kill them.
+func (runner *Runner) checkLimits(activeRunner *ActiveRunner, stopping bool) {
+	var message string
+
+	// Allocations fail when running out of memory under a rlimit, which
+	// most programs don't handle and are killed by a signal.
+	if activeRunner.memoryRlimit && !stopping {
+		if status, ok := activeRunner.Cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			switch status.Signal() {
+			case syscall.SIGABRT, syscall.SIGBUS, syscall.SIGKILL, syscall.SIGSEGV:
+				message = fmt.Sprintf("Killed by the signal '%s', likely after running out of memory under the memory_limit rlimit", status.Signal())
+			}
+		}
+	}
+
+	if len(activeRunner.cgroupPath) > 0 {
+		if oomKills := readCgroupEvent(activeRunner.cgroupPath, "memory.events", "oom_kill"); oomKills > 0 {
+			message = fmt.Sprintf("Killed by memory_limit, %d process(es) were killed for running out of memory", oomKills)
+		}
+
+		// This only succeeds if all processes in the cgroup are gone.
+		if err := os.Remove(activeRunner.cgroupPath); err != nil {
+			log.Printf("Failed to remove cgroup %s: %s", activeRunner.cgroupPath, err)
+		}
+	}
+
+	if len(message) > 0 {
+		runner.changeState(func() { activeRunner.KilledByLimit = "memory_limit" })
+		activeRunner.appendLog("system", message)
+	}
+}
+
+// Create a cgroup next to the cgroup of this program with the limits of
+// the server for the process to move into.
+func createCgroup(name string, server ServerConfig) (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupMountPoint, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroup v2 isn't available")
+	}
+
+	ownCgroup, err := readOwnCgroup()
+	if err != nil {
+		return "", err
+	}
+
+	parentPath := filepath.Join(cgroupMountPoint, filepath.Dir(ownCgroup))
+	cgroupPath := filepath.Join(parentPath, fmt.Sprintf("goprocmgr-%s-%d", name, time.Now().UnixNano()))
+
+	// Try to enable the controllers for the children of the parent,
+	// this fails if they aren't delegated to us.
+	os.WriteFile(filepath.Join(parentPath, "cgroup.subtree_control"), []byte("+memory +cpu"), 0644)
+
+	if err := os.Mkdir(cgroupPath, 0755); err != nil {
+		return "", err
+	}
+
+	settings := make(map[string]string)
+
+	if len(server.MemoryLimit) > 0 {
+		memoryLimit, _ := parseByteSize(server.MemoryLimit)
+		settings["memory.max"] = fmt.Sprintf("%d", memoryLimit)
+		settings["memory.swap.max"] = "0"
+	}
+
+	if server.CPULimit > 0 {
+		settings["cpu.max"] = fmt.Sprintf("%d %d", int(server.CPULimit*cgroupCPUPeriod), cgroupCPUPeriod)
+	}
+
+	for file, value := range settings {
+		// Swap accounting is optional so ignore failures for it.
+		if err := os.WriteFile(filepath.Join(cgroupPath, file), []byte(value), 0644); err != nil && file != "memory.swap.max" {
+			os.Remove(cgroupPath)
+			return "", fmt.Errorf("failed to write %s: %s", file, err)
+		}
+	}
+
+	// The process has to be able to move itself into the cgroup.
+	if err := unix.Access(filepath.Join(cgroupPath, "cgroup.procs"), unix.W_OK); err != nil {
+		os.Remove(cgroupPath)
+		return "", fmt.Errorf("failed to move process into cgroup: %s", err)
+	}
+
+	return cgroupPath, nil
+}
+
+// Read the cgroup v2 path of this program.
+func readOwnCgroup() (string, error) {
+	content, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), nil
+		}
+	}
+
+	return "", fmt.Errorf("couldn't find own cgroup")
+}
+
+// Read a counter from a flat keyed cgroup file such as memory.events.
+func readCgroupEvent(cgroupPath string, file string, key string) uint64 {
+	handle, err := os.Open(filepath.Join(cgroupPath, file))
+	if err != nil {
+		return 0
+	}
+	defer handle.Close()
+
+	scanner := bufio.NewScanner(handle)
+
+	for scanner.Scan() {
+		var value uint64
+
+		if _, err := fmt.Sscanf(scanner.Text(), key+" %d", &value); err == nil {
+			return value
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main // import "github.com/etu/goprocmgr"
+
+import "os/exec"
+
+func (runner *Runner) applyLimits(activeRunner *ActiveRunner, name string, server ServerConfig, cmd *exec.Cmd) {
+	activeRunner.appendLog("system", "Resource limits are only supported on Linux")
+}
+
+func (runner *Runner) checkLimits(activeRunner *ActiveRunner, stopping bool) {
+}
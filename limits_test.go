@@ -0,0 +1,82 @@
+package main // import "github.com/etu/goprocmgr"
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected uint64
+		err      bool
+	}{
+		{value: "1024", expected: 1024},
+		{value: "512K", expected: 512 << 10},
+		{value: "512M", expected: 512 << 20},
+		{value: "2G", expected: 2 << 30},
+		{value: "1T", expected: 1 << 40},
+		{value: "2g", expected: 2 << 30},
+		{value: "256MB", expected: 256 << 20},
+		{value: "256MiB", expected: 256 << 20},
+		{value: " 1G ", expected: 1 << 30},
+		{value: "", err: true},
+		{value: "0", err: true},
+		{value: "0M", err: true},
+		{value: "-1G", err: true},
+		{value: "1.5G", err: true},
+		{value: "G", err: true},
+		{value: "12X", err: true},
+		{value: "20000000T", err: true},
+	}
+
+	for _, test := range tests {
+		size, err := parseByteSize(test.value)
+
+		if test.err {
+			if err == nil {
+				t.Errorf("parseByteSize(%q) = %d, expected an error", test.value, size)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseByteSize(%q) failed: %s", test.value, err)
+		} else if size != test.expected {
+			t.Errorf("parseByteSize(%q) = %d, expected %d", test.value, size, test.expected)
+		}
+	}
+}
+
+func TestValidateLimits(t *testing.T) {
+	tests := []struct {
+		server ServerConfig
+		fields []string
+	}{
+		{server: ServerConfig{}},
+		{server: ServerConfig{MemoryLimit: "512M", CPULimit: 0.5, NoFile: 1024, Nice: 10}},
+		{server: ServerConfig{MemoryLimit: "lots"}, fields: []string{"memory_limit"}},
+		{server: ServerConfig{CPULimit: -1}, fields: []string{"cpu_limit"}},
+		{server: ServerConfig{Nice: 20}, fields: []string{"nice"}},
+		{server: ServerConfig{Nice: -21, CPULimit: -1}, fields: []string{"cpu_limit", "nice"}},
+	}
+
+	for _, test := range tests {
+		errs := test.server.validateLimits()
+
+		var fields []string
+		for _, err := range errs {
+			fields = append(fields, err.Field)
+		}
+
+		if len(fields) != len(test.fields) {
+			t.Errorf("validateLimits(%+v) = %s, expected errors for %v", test.server, errs, test.fields)
+			continue
+		}
+
+		for idx := range fields {
+			if fields[idx] != test.fields[idx] {
+				t.Errorf("validateLimits(%+v) = %s, expected errors for %v", test.server, errs, test.fields)
+				break
+			}
+		}
+	}
+}
@@ -8,26 +8,52 @@ import (
 func main() {
 	var config Config
 	var configFile string
+	var configConvertFlag string
 	var addFlag string
+	var addTypeFlag string
 	var listFlag bool
 	var listFormat string
+	var topFlag bool
 	var versionFlag bool
 	var serveFlag bool
 	var removeFlag string
 	var startFlag string
 	var stopFlag string
+	var restartFlag string
 	var logsFlag string
+	var importProcfileFlag string
+	var exportProcfileFlag string
+	var trackFlag bool
+	var importComposeFlag string
+	var exportFlag string
+	var importFlag string
+	var serversFlag string
+	var baseDirFlag string
+	var overwriteFlag bool
 
 	flag.StringVar(&configFile, "config", config.GuessFileName(""), "Specify config file")
+	flag.StringVar(&configConvertFlag, "config-convert", "", "Convert the config file to another file, the format (json, yaml, toml) is chosen by its extension")
 	flag.BoolVar(&serveFlag, "serve", true, "Run the serve command (start the web server)")
 	flag.BoolVar(&listFlag, "list", false, "List the stored servers")
 	flag.StringVar(&listFormat, "list-format", "table", "List format (table, csv) when using the list command")
+	flag.BoolVar(&topFlag, "top", false, "Show live resource usage of the running servers")
 	flag.BoolVar(&versionFlag, "version", false, "Print the version")
 	flag.StringVar(&addFlag, "add", "", "Add a new server, will capture the current directory and environment and then takes the command as an argument")
+	flag.StringVar(&addTypeFlag, "add-type", ServerTypeServer, "Type of server to add (server, task) when using the add command")
 	flag.StringVar(&removeFlag, "remove", "", "Remove an existing server by it's name")
 	flag.StringVar(&startFlag, "start", "", "Start an existing server by it's name")
 	flag.StringVar(&stopFlag, "stop", "", "Stop an existing server by it's name")
+	flag.StringVar(&restartFlag, "restart", "", "Restart an existing server by it's name")
 	flag.StringVar(&logsFlag, "logs", "", "Tail the logs from an existing server by it's name")
+	flag.StringVar(&importProcfileFlag, "import-procfile", "", "Import the processes of a Procfile as servers")
+	flag.StringVar(&exportProcfileFlag, "export-procfile", "", "Export the servers that runs in the directory of a Procfile to it, or to stdout with -")
+	flag.BoolVar(&trackFlag, "track", false, "Track the Procfile when using the import-procfile command, to keep the servers in sync with it")
+	flag.StringVar(&importComposeFlag, "import-compose", "", "Import the services of a compose file as servers")
+	flag.StringVar(&exportFlag, "export", "", "Export servers to a bundle file to share with others, or to stdout with -")
+	flag.StringVar(&importFlag, "import", "", "Import the servers of a bundle file")
+	flag.StringVar(&serversFlag, "servers", "", "Comma separated list of servers to export when using the export command, all servers by default")
+	flag.StringVar(&baseDirFlag, "base-dir", "", "Directory that the directories of servers are relative to in bundles, defaults to the common directory of the servers when exporting and the current directory when importing")
+	flag.BoolVar(&overwriteFlag, "overwrite", false, "Overwrite existing servers when using the import command")
 	flag.Parse()
 
 	if versionFlag {
@@ -35,18 +61,24 @@ func main() {
 		return
 	}
 
-	runner := Runner{config: &config}
-	serve := NewServe(&config, &runner)
+	runner := NewRunner(&config)
+	serve := NewServe(&config, runner)
 	cli := Cli{config: &config}
 
 	config.Read(configFile)
 
 	switch true {
+	case len(configConvertFlag) > 0:
+		cli.ConvertConfig(configConvertFlag)
+
 	case listFlag:
 		cli.List(listFormat)
 
+	case topFlag:
+		cli.Top()
+
 	case len(addFlag) > 0:
-		cli.Add(addFlag)
+		cli.Add(addFlag, addTypeFlag)
 
 	case len(removeFlag) > 0:
 		cli.Remove(removeFlag)
@@ -57,9 +89,27 @@ func main() {
 	case len(stopFlag) > 0:
 		cli.Stop(stopFlag)
 
+	case len(restartFlag) > 0:
+		cli.Restart(restartFlag)
+
 	case len(logsFlag) > 0:
 		cli.Logs(logsFlag)
 
+	case len(importProcfileFlag) > 0:
+		cli.ImportProcfile(importProcfileFlag, trackFlag)
+
+	case len(exportProcfileFlag) > 0:
+		cli.ExportProcfile(exportProcfileFlag)
+
+	case len(importComposeFlag) > 0:
+		cli.ImportCompose(importComposeFlag)
+
+	case len(exportFlag) > 0:
+		cli.Export(exportFlag, serversFlag, baseDirFlag)
+
+	case len(importFlag) > 0:
+		cli.Import(importFlag, baseDirFlag, overwriteFlag)
+
 	case serveFlag:
 		serve.Run()
 	}
@@ -0,0 +1,132 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A metric in the Prometheus text exposition format.
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	samples []metricSample
+}
+
+type metricSample struct {
+	labels map[string]string
+	value  float64
+}
+
+// Write the metrics of all servers in the Prometheus text exposition
+// format.
+func (serve *Serve) WriteMetrics(w io.Writer) {
+	running := metric{name: "goprocmgr_server_running", help: "Whether the server is running (1) or not (0).", kind: "gauge"}
+	starts := metric{name: "goprocmgr_server_starts_total", help: "Number of times the server has been started.", kind: "counter"}
+	restarts := metric{name: "goprocmgr_server_restarts_total", help: "Number of times the server has been restarted.", kind: "counter"}
+	crashes := metric{name: "goprocmgr_server_crashes_total", help: "Number of times the server has exited without being stopped, or the task has failed.", kind: "counter"}
+	exitCode := metric{name: "goprocmgr_server_exit_code", help: "Exit code of the last run of the server that has exited.", kind: "gauge"}
+	logLines := metric{name: "goprocmgr_server_log_lines", help: "Number of log lines of the current run of the server by stream.", kind: "gauge"}
+	uptime := metric{name: "goprocmgr_server_uptime_seconds", help: "Number of seconds the server has been running.", kind: "gauge"}
+	cpu := metric{name: "goprocmgr_server_cpu_percent", help: "CPU usage of the server and its descendants in percent of one CPU.", kind: "gauge"}
+	memory := metric{name: "goprocmgr_server_memory_rss_bytes", help: "Resident memory of the server and its descendants in bytes.", kind: "gauge"}
+
+	serve.runner.stateMutex.Lock()
+	defer serve.runner.stateMutex.Unlock()
+
+	// Go through all configured servers in a stable order
+	var names []string
+	for name := range serve.config.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		server, err := serve.getServer(name)
+		if err != nil {
+			continue
+		}
+
+		labels := map[string]string{"name": name}
+
+		running.add(map[string]string{"name": name, "type": server.Type}, boolToFloat(server.IsRunning))
+		logLines.add(map[string]string{"name": name, "stream": "stdout"}, float64(server.StdoutCount))
+		logLines.add(map[string]string{"name": name, "stream": "stderr"}, float64(server.StderrCount))
+
+		if counters, ok := serve.runner.Counters[name]; ok {
+			starts.add(labels, float64(counters.Starts))
+			restarts.add(labels, float64(counters.Restarts))
+			crashes.add(labels, float64(counters.Crashes))
+		} else {
+			starts.add(labels, 0)
+			restarts.add(labels, 0)
+			crashes.add(labels, 0)
+		}
+
+		if server.HasExited {
+			exitCode.add(labels, float64(server.ExitCode))
+		}
+
+		if server.IsRunning {
+			uptime.add(labels, time.Since(serve.runner.ActiveProcesses[name].StartedAt).Seconds())
+		}
+
+		if server.Stats != nil {
+			cpu.add(labels, server.Stats.CPUPercent)
+			memory.add(labels, float64(server.Stats.MemoryRSS))
+		}
+	}
+
+	websocketClients := metric{name: "goprocmgr_websocket_clients", help: "Number of connected websocket clients.", kind: "gauge"}
+	websocketClients.add(nil, float64(serve.clientCount()))
+
+	for _, m := range []metric{running, starts, restarts, crashes, exitCode, logLines, uptime, cpu, memory, websocketClients} {
+		m.write(w)
+	}
+}
+
+func (m *metric) add(labels map[string]string, value float64) {
+	m.samples = append(m.samples, metricSample{labels: labels, value: value})
+}
+
+func (m *metric) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+
+	for _, sample := range m.samples {
+		fmt.Fprintf(w, "%s%s %g\n", m.name, formatMetricLabels(sample.labels), sample.value)
+	}
+}
+
+// Format labels as {key="value",...} with the keys sorted.
+func formatMetricLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	var keys []string
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	var pairs []string
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, key, escaper.Replace(labels[key])))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,38 @@
+//go:build linux
+
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Get the user of the process serving a connected unix socket.
+func socketPeerUID(conn net.Conn, socketPath string) (int, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return -1, fmt.Errorf("not a unix socket connection")
+	}
+
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+
+	var ucred *unix.Ucred
+	var credErr error
+
+	if err := rawConn.Control(func(fd uintptr) {
+		ucred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return -1, err
+	}
+
+	if credErr != nil {
+		return -1, credErr
+	}
+
+	return int(ucred.Uid), nil
+}
@@ -0,0 +1,26 @@
+//go:build unix && !linux
+
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Get the owner of the socket file since the credentials of the process
+// serving a socket aren't available the same way on all platforms.
+func socketPeerUID(conn net.Conn, socketPath string) (int, error) {
+	info, err := os.Lstat(socketPath)
+	if err != nil {
+		return -1, err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, fmt.Errorf("failed to read the owner of %s", socketPath)
+	}
+
+	return int(stat.Uid), nil
+}
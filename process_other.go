@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main // import "github.com/etu/goprocmgr"
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// Process groups aren't available, so only the process is killed.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Start a command in a process group of its own.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Kill the process group of a command started with setProcessGroup.
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
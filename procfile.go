@@ -0,0 +1,134 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The name of the Procfile of a project.
+const procfileName = "Procfile"
+
+// A process line of a Procfile, the same format as foreman and honcho.
+var procfileLinePattern = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.+)$`)
+
+// Parse the content of a Procfile into servers, the servers are named by
+// the directory of the Procfile and the process name and runs in the
+// directory of the Procfile. The commands are run through a shell since
+// they are command lines that may use variables such as $PORT.
+func parseProcfile(fileName string, content []byte) (map[string]ServerConfig, error) {
+	directory, err := filepath.Abs(filepath.Dir(fileName))
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make(map[string]ServerConfig)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		match := procfileLinePattern.FindStringSubmatch(text)
+		if match == nil {
+			return nil, fmt.Errorf("line %d: expected 'name: command'", line)
+		}
+
+		name := projectServerName(directory, match[1])
+
+		if _, ok := servers[name]; ok {
+			return nil, fmt.Errorf("line %d: process %s is defined more than once", line, match[1])
+		}
+
+		servers[name] = ServerConfig{
+			Name:      name,
+			Directory: directory,
+			Command:   strings.TrimSpace(match[2]),
+			UseShell:  true,
+		}
+	}
+
+	return servers, scanner.Err()
+}
+
+// Get the name of a server for a process of a project, such as a process
+// in a Procfile or a service in a compose file.
+func projectServerName(directory string, process string) string {
+	return filepath.Base(directory) + "-" + process
+}
+
+// Qualify the names of the servers of a Procfile with the parent
+// directories of the Procfile until none of them are already defined, to
+// tell apart projects in directories with the same name.
+func qualifyProcfileServers(directory string, servers map[string]ServerConfig, defined func(name string) bool) map[string]ServerConfig {
+	prefix := ""
+	parent := filepath.Dir(directory)
+
+	for {
+		collides := false
+		for name := range servers {
+			if defined(prefix + name) {
+				collides = true
+				break
+			}
+		}
+
+		if !collides || parent == filepath.Dir(parent) {
+			break
+		}
+
+		prefix = filepath.Base(parent) + "-" + prefix
+		parent = filepath.Dir(parent)
+	}
+
+	if len(prefix) == 0 {
+		return servers
+	}
+
+	qualified := make(map[string]ServerConfig, len(servers))
+	for name, server := range servers {
+		server.Name = prefix + name
+		qualified[prefix+name] = server
+	}
+
+	return qualified
+}
+
+// Format the servers that runs in a directory as a Procfile, tasks are
+// left out since a Procfile only has long running processes. The names
+// of the servers that were exported are returned.
+func formatProcfile(directory string, servers map[string]ServerConfig) ([]byte, []string) {
+	var names []string
+
+	for name, server := range servers {
+		if server.Directory == directory && !server.IsTask() {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	var buffer bytes.Buffer
+
+	for _, name := range names {
+		// Use the process name that the server was imported with, the
+		// name may be qualified with parent directories.
+		process := name
+		if index := strings.Index(name, filepath.Base(directory)+"-"); index >= 0 {
+			process = name[index+len(filepath.Base(directory))+1:]
+		}
+
+		process = strings.ReplaceAll(process, ".", "_")
+
+		fmt.Fprintf(&buffer, "%s: %s\n", process, servers[name].Command)
+	}
+
+	return buffer.Bytes(), names
+}
@@ -0,0 +1,102 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseProcfile(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected map[string]ServerConfig
+		err      bool
+	}{
+		{
+			content: "web: bundle exec rails server -p $PORT\nworker:bundle exec sidekiq\n",
+			expected: map[string]ServerConfig{
+				"app-web":    {Name: "app-web", Directory: "/srv/app", Command: "bundle exec rails server -p $PORT", UseShell: true},
+				"app-worker": {Name: "app-worker", Directory: "/srv/app", Command: "bundle exec sidekiq", UseShell: true},
+			},
+		},
+		{
+			content: "# The processes\n\n  web: ./web  \n",
+			expected: map[string]ServerConfig{
+				"app-web": {Name: "app-web", Directory: "/srv/app", Command: "./web", UseShell: true},
+			},
+		},
+		{
+			content:  "",
+			expected: map[string]ServerConfig{},
+		},
+		{content: "web ./web\n", err: true},
+		{content: "web:\n", err: true},
+		{content: "web server: ./web\n", err: true},
+		{content: "web: ./web\nweb: ./other\n", err: true},
+	}
+
+	for _, test := range tests {
+		servers, err := parseProcfile("/srv/app/Procfile", []byte(test.content))
+
+		if test.err {
+			if err == nil {
+				t.Errorf("parseProcfile(%q) = %+v, expected an error", test.content, servers)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseProcfile(%q) failed: %s", test.content, err)
+		} else if !reflect.DeepEqual(servers, test.expected) {
+			t.Errorf("parseProcfile(%q) = %+v, expected %+v", test.content, servers, test.expected)
+		}
+	}
+}
+
+func TestQualifyProcfileServers(t *testing.T) {
+	servers := map[string]ServerConfig{
+		"app-web":    {Name: "app-web"},
+		"app-worker": {Name: "app-worker"},
+	}
+
+	tests := []struct {
+		defined  []string
+		expected []string
+	}{
+		{defined: nil, expected: []string{"app-web", "app-worker"}},
+		{defined: []string{"other"}, expected: []string{"app-web", "app-worker"}},
+		{defined: []string{"app-worker"}, expected: []string{"client-app-web", "client-app-worker"}},
+		{defined: []string{"app-web", "client-app-web"}, expected: []string{"projects-client-app-web", "projects-client-app-worker"}},
+		{defined: []string{"app-web", "client-app-web", "projects-client-app-web", "home-projects-client-app-web"}, expected: []string{"home-projects-client-app-web", "home-projects-client-app-worker"}},
+	}
+
+	for _, test := range tests {
+		defined := func(name string) bool {
+			for _, definedName := range test.defined {
+				if name == definedName {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		qualified := qualifyProcfileServers("/home/projects/client/app", servers, defined)
+
+		var names []string
+		for name, server := range qualified {
+			if server.Name != name {
+				t.Errorf("qualifyProcfileServers with %v gave server %s the name %s", test.defined, name, server.Name)
+			}
+
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("qualifyProcfileServers with %v = %v, expected %v", test.defined, names, test.expected)
+		}
+	}
+}
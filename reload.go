@@ -0,0 +1,189 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+const (
+	// How often to check the config file for changes made outside of
+	// the API. Polling is used since the config file often is a symlink
+	// into a dotfiles repository which makes file events unreliable.
+	configPollInterval = 2 * time.Second
+)
+
+// The changes to the servers of a config reload.
+type ConfigChanges struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// Read the config file again and replace the config with it if it's
+// valid. Settings that are used to listen only applies after restart.
+func (config *Config) Reload() (ConfigChanges, error) {
+	var changes ConfigChanges
+
+	reloaded := Config{configFileName: config.configFileName}
+	reloaded.setDefaults()
+
+	// The config file may not exist when all servers are in included
+	// files.
+	content, err := os.ReadFile(config.configFileName)
+	if err != nil && !os.IsNotExist(err) {
+		return changes, err
+	}
+
+	if content != nil {
+		if err := reloaded.parse(content); err != nil {
+			return changes, err
+		}
+	}
+
+	if err := reloaded.readIncludes(); err != nil {
+		return changes, err
+	}
+
+	if content != nil {
+		reloaded.savedContent, _ = reloaded.encode(content)
+	}
+
+	// Keep the current token if it has been removed from the file, to
+	// not lock everyone out until the next restart.
+	if len(reloaded.Settings.Token) == 0 {
+		reloaded.Settings.Token = config.Settings.Token
+	}
+
+	for name, server := range reloaded.Servers {
+		if current, ok := config.Servers[name]; !ok {
+			changes.Added = append(changes.Added, name)
+		} else if !current.Equal(server) {
+			changes.Changed = append(changes.Changed, name)
+		}
+	}
+
+	for name := range config.Servers {
+		if _, ok := reloaded.Servers[name]; !ok {
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Changed)
+
+	config.Settings = reloaded.Settings
+	config.Servers = reloaded.Servers
+	config.loadedContent = reloaded.loadedContent
+	config.savedContent = reloaded.savedContent
+	config.includes = reloaded.includes
+	config.serverIncludes = reloaded.serverIncludes
+
+	return changes, nil
+}
+
+// Check if the config file or the included files has been changed since
+// they were last read or written by us, the current content of the
+// files is returned.
+func (config *Config) fileChanged() ([]byte, bool) {
+	content, _ := os.ReadFile(config.configFileName)
+	includesContent, includesChanged := config.includesChanged()
+
+	return append(content, includesContent...), includesChanged || !bytes.Equal(content, config.loadedContent)
+}
+
+// Reload the config and stop the servers that have been removed from
+// it. Running servers with a changed config keeps running with the old
+// config and are flagged as changed until they're restarted. The caller
+// has to run as an exclusive operation.
+func (serve *Serve) reloadConfig() (ConfigChanges, error) {
+	previous := serve.config.Settings
+
+	var changes ConfigChanges
+	err := serve.changeConfig(func() (err error) {
+		changes, err = serve.config.Reload()
+		return err
+	})
+	if err != nil {
+		return changes, err
+	}
+
+	current := serve.config.Settings
+
+	if previous.ListenAddress != current.ListenAddress || previous.ListenPort != current.ListenPort ||
+		previous.ListenNetwork != current.ListenNetwork || previous.ListenSocket != current.ListenSocket ||
+		previous.TLSEnabled != current.TLSEnabled || previous.TLSCertFile != current.TLSCertFile || previous.TLSKeyFile != current.TLSKeyFile {
+		log.Println("The settings to listen with has changed, restart to apply them")
+	}
+
+	for _, name := range changes.Removed {
+		if err := serve.runner.Stop(name, serve); err != nil {
+			log.Printf("Failed to stop removed server %s: %s\n", name, err)
+		}
+	}
+
+	serve.warnInvalidServers()
+
+	log.Printf("Reloaded config file %s: %s\n", serve.config.configFileName, changes)
+
+	serve.notifyStateChange()
+
+	return changes, nil
+}
+
+func (changes ConfigChanges) String() string {
+	return fmt.Sprintf("added %v, removed %v, changed %v", changes.Added, changes.Removed, changes.Changed)
+}
+
+// Reload the config when the file changes or on SIGHUP until the
+// program exits.
+func (serve *Serve) reloadConfigLoop() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	// Content of the file that failed to load, to not try to load it
+	// again until it's changed.
+	var rejected []byte
+
+	for {
+		select {
+		case <-hangup:
+			log.Println("Received SIGHUP, reloading config")
+			serve.reloadChangedConfig(true, &rejected)
+
+		case <-ticker.C:
+			serve.reloadChangedConfig(false, &rejected)
+		}
+	}
+}
+
+// Reload the config as an exclusive operation if it has changed since it
+// was last read or written and isn't the rejected content, or if forced.
+func (serve *Serve) reloadChangedConfig(force bool, rejected *[]byte) {
+	serve.runner.operationMutex.Lock()
+	defer serve.runner.operationMutex.Unlock()
+
+	if !force {
+		content, changed := serve.config.fileChanged()
+		if !changed || bytes.Equal(content, *rejected) {
+			return
+		}
+
+		log.Println("Config file changed, reloading config")
+	}
+
+	if _, err := serve.reloadConfig(); err != nil {
+		log.Printf("Failed to reload config, keeping the current config: %s\n", err)
+
+		*rejected, _ = serve.config.fileChanged()
+	}
+}
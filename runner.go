@@ -3,18 +3,68 @@ package main // import "github.com/etu/goprocmgr"
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
+const (
+	// Time to wait for the remaining output of a process after it
+	// has exited.
+	outputDrainTimeout = time.Second
+
+	// Time that hooks and pre-start tasks may run before they are
+	// killed, since starting and stopping servers wait for them.
+	hookTimeout = 5 * time.Minute
+	taskTimeout = 30 * time.Minute
+)
+
 type Runner struct {
 	config          *Config
 	ActiveProcesses map[string]*ActiveRunner
+	Counters        map[string]*RunnerCounters
+	watchers        map[string]*FileWatcher
+
+	// Operations that start, stop or change servers run one at a time
+	// since they may wait for processes for a long time.
+	operationMutex sync.Mutex
+
+	// Guards the maps of the runner and the config. It's held while
+	// changing them and by readers outside of operations, but never
+	// while waiting for processes.
+	stateMutex sync.Mutex
+}
+
+func NewRunner(config *Config) *Runner {
+	return &Runner{
+		config:          config,
+		ActiveProcesses: make(map[string]*ActiveRunner),
+		Counters:        make(map[string]*RunnerCounters),
+		watchers:        make(map[string]*FileWatcher),
+	}
+}
+
+// Run a change to the state of the runner or the config while holding
+// the state lock, the caller has to hold the operation lock.
+func (runner *Runner) changeState(change func()) {
+	runner.stateMutex.Lock()
+	defer runner.stateMutex.Unlock()
+
+	change()
+}
+
+// Counters of events for a server since the program started.
+type RunnerCounters struct {
+	Starts   uint
+	Restarts uint
+	Crashes  uint // Exits that wasn't requested by a stop
 }
 
 type LogEntry struct {
@@ -24,35 +74,394 @@ type LogEntry struct {
 }
 
 type ActiveRunner struct {
-	Cmd  *exec.Cmd
-	Port uint
-	Logs []LogEntry
+	Cmd      *exec.Cmd
+	Port     uint
+	Logs     []LogEntry
+	Done     chan struct{} // Closed when the process has exited
+	Exited   bool
+	ExitCode int
+	stopping bool // Set when the process has been asked to stop
+
+	// The state lock of the runner, the logs are appended to while the
+	// process runs and are read by the API.
+	stateMutex *sync.Mutex
+
+	// The config the process was started with, to tell if the config
+	// has changed since it was started.
+	config ServerConfig
+
+	// The environment of the process and its hooks, and the secret
+	// values to mask in the logs.
+	environment []string
+	secrets     []string
+
+	// The limit that killed the process, if any.
+	KilledByLimit string
+	cgroupPath    string
+	memoryRlimit  bool // Set when the memory limit falls back to a rlimit
+
+	// Resource usage of the process and its descendants.
+	StartedAt    time.Time
+	Stats        *ProcessStats
+	StatsHistory []ProcessSample
+	lastCPUTime  time.Duration
+	lastSampleAt time.Time
 }
 
-func (runner *Runner) Start(name string, serve *Serve) error {
-	// Init active processes map
-	if runner.ActiveProcesses == nil {
-		runner.ActiveProcesses = make(map[string]*ActiveRunner)
+// Start a command with stdout and stderr captured into the log stream
+// of the runner. Returns a function that waits for the command to exit
+// and for the remaining output to be read.
+func (activeRunner *ActiveRunner) startCaptured(cmd *exec.Cmd, serve *Serve) (func(), error) {
+	// Use pipes of our own rather than the ones from exec.Cmd to be
+	// able to read the remaining output after the process exited.
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up stdout pipe: %s", err)
 	}
 
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdoutReader.Close()
+		stdoutWriter.Close()
+
+		return nil, fmt.Errorf("failed to set up stderr pipe: %s", err)
+	}
+
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+
+	err = cmd.Start()
+
+	// The writing ends are only used by the process from now on.
+	stdoutWriter.Close()
+	stderrWriter.Close()
+
+	if err != nil {
+		stdoutReader.Close()
+		stderrReader.Close()
+
+		return nil, err
+	}
+
+	// Keep track of the output readers to know when all output is read.
+	var outputWaitGroup sync.WaitGroup
+	outputWaitGroup.Add(2)
+
+	go activeRunner.captureOutput(stdoutReader, "stdout", &outputWaitGroup, serve)
+	go activeRunner.captureOutput(stderrReader, "stderr", &outputWaitGroup, serve)
+
+	return func() {
+		cmd.Wait()
+
+		outputDone := make(chan struct{})
+		go func() {
+			outputWaitGroup.Wait()
+			close(outputDone)
+		}()
+
+		// Processes started in the background by the command may
+		// keep the output open, so don't wait for it forever.
+		select {
+		case <-outputDone:
+		case <-time.After(outputDrainTimeout):
+			stdoutReader.Close()
+			stderrReader.Close()
+			<-outputDone
+		}
+
+		stdoutReader.Close()
+		stderrReader.Close()
+	}, nil
+}
+
+// Read output line by line from a reader into the log stream of the
+// runner until the reader is closed.
+func (activeRunner *ActiveRunner) captureOutput(reader io.Reader, output string, waitGroup *sync.WaitGroup, serve *Serve) {
+	defer waitGroup.Done()
+
+	scanner := bufio.NewScanner(reader)
+
+	for scanner.Scan() {
+		// Log it to the common log
+		activeRunner.appendLog(output, scanner.Text())
+
+		serve.notifyStateChange()
+	}
+}
+
+// Check if the process of the run has exited, unlike Exited this is
+// safe to check without holding the state lock.
+func (activeRunner *ActiveRunner) hasExited() bool {
+	select {
+	case <-activeRunner.Done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Append a log entry to the log stream of the runner, this takes the
+// state lock so it can't be called while holding it.
+func (activeRunner *ActiveRunner) appendLog(output string, message string) {
+	entry := LogEntry{
+		Timestamp: time.Now(),
+		Message:   maskSecrets(message, activeRunner.secrets),
+		Output:    output,
+	}
+
+	activeRunner.stateMutex.Lock()
+	defer activeRunner.stateMutex.Unlock()
+
+	activeRunner.Logs = append(activeRunner.Logs, entry)
+}
+
+// Get the log stream of the runner.
+func (activeRunner *ActiveRunner) logs() []LogEntry {
+	activeRunner.stateMutex.Lock()
+	defer activeRunner.stateMutex.Unlock()
+
+	return activeRunner.Logs
+}
+
+type RestartOptions struct {
+	KeepPort bool   // Start the server again on the same port
+	KeepLogs bool   // Keep the log buffer from before the restart
+	Reason   string // Message to log as separator between the runs
+}
+
+func (runner *Runner) Start(name string, serve *Serve) error {
+	return runner.start(name, 0, nil, serve)
+}
+
+// Start a server on the given port with the given logs from before,
+// if the port is zero a random port is used.
+func (runner *Runner) start(name string, port uint, logs []LogEntry, serve *Serve) error {
 	if _, ok := runner.config.Servers[name]; !ok {
 		return fmt.Errorf("unknown server %s", name)
 	}
 
-	if _, ok := runner.ActiveProcesses[name]; ok {
-		return fmt.Errorf("server is already running: %s", name)
+	if activeRunner, ok := runner.ActiveProcesses[name]; ok {
+		if !activeRunner.hasExited() {
+			return fmt.Errorf("server is already running: %s", name)
+		}
+
+		// Drop the state of the previous run that has exited.
+		runner.changeState(func() { delete(runner.ActiveProcesses, name) })
 	}
 
-	// Split the command on the first space since exec.Command will
-	// look for the first argument only in the path as a binary name.
-	splitCmd := strings.SplitN(runner.config.Servers[name].Command, " ", 2)
+	// Run the tasks that has to complete before the server starts.
+	for _, taskName := range runner.config.Servers[name].PreStartTasks {
+		if err := runner.runTask(taskName, serve); err != nil {
+			return fmt.Errorf("pre-start task failed: %s", err)
+		}
+	}
+
+	server := runner.config.Servers[name]
+
+	// Store my active processes
+	activeRunner := ActiveRunner{Done: make(chan struct{}), Logs: logs, config: server, stateMutex: &runner.stateMutex}
+
+	// Randomize a port to supply as environment variable.
+	if port == 0 {
+		randomPort, err := runner.randomizePortNumber()
+		if err != nil {
+			return err
+		}
+
+		port = randomPort
+	}
+
+	// Store the port in the runner to expose in the API.
+	activeRunner.Port = port
+
+	secrets, err := server.loadSecrets()
+	if err != nil {
+		return fmt.Errorf("failed to read secrets file: %s", err)
+	}
+
+	// Resolve the references to other servers in the environment, the
+	// config of the run is kept as it is to detect changes to it.
+	resolved := server
+	resolved.Environment, err = runner.resolveEnvironment(server, port)
+	if err != nil {
+		return err
+	}
+
+	activeRunner.environment = runner.buildEnvironment(resolved, secrets, port)
+	activeRunner.secrets = resolved.secretValues(secrets)
+
+	// Run the hooks that has to succeed before the server starts, if
+	// any of them fails we keep the failed run around to be able to
+	// read the output of the hooks.
+	for _, hook := range server.PreStart {
+		if exitCode := runner.runHook(&activeRunner, server, "pre_start", hook, serve); exitCode != 0 {
+			activeRunner.ExitCode = exitCode
+			activeRunner.Exited = true
+			close(activeRunner.Done)
+
+			runner.changeState(func() { runner.ActiveProcesses[name] = &activeRunner })
+			serve.notifyStateChange()
+
+			return fmt.Errorf("pre_start hook '%s' failed with exit code %d", hook, exitCode)
+		}
+	}
 
 	// Set up a command.
-	var cmd *exec.Cmd
+	cmd := runner.buildCommand(server)
+	activeRunner.Cmd = cmd
+
+	// Specify runtime directory.
+	cmd.Dir = server.Directory
+
+	// Set environment for running command.
+	cmd.Env = activeRunner.environment
+
+	// Apply the resource limits to the process before it's started.
+	if server.HasLimits() {
+		runner.applyLimits(&activeRunner, name, server, cmd)
+	}
+
+	// Start the command and read stdout and stderr while the
+	// command is executed
+	wait, err := activeRunner.startCaptured(cmd, serve)
+	if err != nil {
+		return fmt.Errorf("failed to start process: %s", err)
+	}
+
+	activeRunner.StartedAt = time.Now()
+	runner.changeState(func() { runner.counters(name).Starts++ })
+
+	// Wait for the process to exit to store the exit code, this is
+	// what makes a task complete and a server crash.
+	go func() {
+		wait()
+
+		var stopping bool
+
+		// The server may have been renamed while it was running.
+		name := name
+
+		runner.changeState(func() {
+			activeRunner.ExitCode = cmd.ProcessState.ExitCode()
+			stopping = activeRunner.stopping
+			name = activeRunner.config.Name
+
+			// A server exiting or a task failing on its own is a crash.
+			if !stopping && (!server.IsTask() || activeRunner.ExitCode != 0) {
+				runner.counters(name).Crashes++
+			}
+		})
+
+		runner.checkLimits(&activeRunner, stopping)
+
+		if len(activeRunner.KilledByLimit) > 0 {
+			log.Printf("The process of %s was killed by %s", name, activeRunner.KilledByLimit)
+		}
+
+		if server.IsTask() {
+			activeRunner.appendLog("system", fmt.Sprintf("Task finished with exit code %d", activeRunner.ExitCode))
+		} else {
+			activeRunner.appendLog("system", fmt.Sprintf("Server exited with exit code %d", activeRunner.ExitCode))
+		}
+
+		// Run the hooks to clean up after the process, failures
+		// are only logged since the process is gone either way.
+		for _, hook := range server.PostStop {
+			if exitCode := runner.runHook(&activeRunner, server, "post_stop", hook, serve); exitCode != 0 {
+				log.Printf("The post_stop hook '%s' for %s failed with exit code %d", hook, name, exitCode)
+			}
+		}
+
+		runner.changeState(func() { activeRunner.Exited = true })
+		close(activeRunner.Done)
+
+		serve.notifyStateChange()
+	}()
+
+	// Store the Cmd process as an active process
+	runner.changeState(func() { runner.ActiveProcesses[name] = &activeRunner })
+
+	// Watch for file changes to restart the server on if configured.
+	if len(server.Watch) > 0 {
+		runner.startWatcher(name, serve)
+	}
+
+	// Notify state change on start
+	serve.notifyStateChange()
+
+	return nil
+}
 
-	if runner.config.Servers[name].UseDirenv {
+// Start watching the directory of a server for changes that should
+// restart the server, unless it's already being watched.
+func (runner *Runner) startWatcher(name string, serve *Serve) {
+	if _, ok := runner.watchers[name]; ok {
+		return
+	}
+
+	server := runner.config.Servers[name]
+
+	var watcher *FileWatcher
+	var err error
+
+	watcher, err = NewFileWatcher(server.Directory, server.Watch, server.WatchIgnore, func(relativePath string) {
+		runner.operationMutex.Lock()
+		defer runner.operationMutex.Unlock()
+
+		// The server may have been stopped while waiting for the lock.
+		if runner.watchers[name] != watcher {
+			return
+		}
+
+		log.Printf("Restarting %s due to change in %s", name, relativePath)
+
+		err := runner.Restart(name, RestartOptions{
+			KeepPort: true,
+			KeepLogs: true,
+			Reason:   fmt.Sprintf("Restarted due to change in %s", relativePath),
+		}, serve)
+
+		if err != nil {
+			log.Printf("Failed to restart %s: %s", name, err)
+		}
+	})
+
+	if err != nil {
+		log.Printf("Failed to watch for changes for %s: %s", name, err)
+		runner.ActiveProcesses[name].appendLog("system", fmt.Sprintf("Failed to watch for changes: %s", err))
+
+		return
+	}
+
+	runner.watchers[name] = watcher
+}
+
+// Stop watching the directory of a server for changes.
+func (runner *Runner) stopWatcher(name string) {
+	if watcher, ok := runner.watchers[name]; ok {
+		watcher.Close()
+		delete(runner.watchers, name)
+	}
+}
+
+// Build the command to run for a server.
+func (runner *Runner) buildCommand(server ServerConfig) *exec.Cmd {
+	// Run the command line through a shell the same way as hooks.
+	if server.UseShell {
+		if server.UseDirenv {
+			return exec.Command("direnv", "exec", ".", "sh", "-c", server.Command)
+		}
+
+		return exec.Command("sh", "-c", server.Command)
+	}
+
+	// Split the command on the first space since exec.Command will
+	// look for the first argument only in the path as a binary name.
+	splitCmd := strings.SplitN(server.Command, " ", 2)
+
+	if server.UseDirenv {
 		if len(splitCmd) > 1 {
-			cmd = exec.Command(
+			return exec.Command(
 				"direnv",
 				"exec",
 				".",
@@ -60,96 +469,223 @@ func (runner *Runner) Start(name string, serve *Serve) error {
 				"--",
 				splitCmd[1],
 			)
-		} else {
-			cmd = exec.Command(
-				"direnv",
-				"exec",
-				".",
-				runner.config.Servers[name].Command,
-			)
 		}
-	} else {
-		if len(splitCmd) > 1 {
-			cmd = exec.Command(splitCmd[0], splitCmd[1])
-		} else {
-			cmd = exec.Command(runner.config.Servers[name].Command)
+
+		return exec.Command(
+			"direnv",
+			"exec",
+			".",
+			server.Command,
+		)
+	}
+
+	if len(splitCmd) > 1 {
+		return exec.Command(splitCmd[0], splitCmd[1])
+	}
+
+	return exec.Command(server.Command)
+}
+
+// Build the environment to run the commands of a server with.
+func (runner *Runner) buildEnvironment(server ServerConfig, secrets map[string]string, port uint) []string {
+	// First inherit the env from the running command.
+	env := os.Environ()
+
+	// Then, add the environment variables from the config and the
+	// secrets file, later variables replaces earlier ones.
+	for _, variables := range []map[string]string{server.Environment, secrets} {
+		var keys []string
+		for key := range variables {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			env = append(env, fmt.Sprintf("%s=%s", key, variables[key]))
 		}
 	}
 
-	// Store my active processes
-	activeRunner := ActiveRunner{Cmd: cmd}
+	env = append(env, fmt.Sprintf("PORT=%d", port))
 
-	// Specify runtime directory.
-	cmd.Dir = runner.config.Servers[name].Directory
+	return env
+}
 
-	// Randomize a port to supply as environment variable.
-	port, err := runner.randomizePortNumber()
-	if err != nil {
-		return err
+// Run a hook command through a shell in the directory and environment
+// of the server, the output is captured into the log stream of the
+// server. Returns the exit code of the hook.
+func (runner *Runner) runHook(activeRunner *ActiveRunner, server ServerConfig, stage string, hook string, serve *Serve) int {
+	var cmd *exec.Cmd
+
+	if server.UseDirenv {
+		cmd = exec.Command("direnv", "exec", ".", "sh", "-c", hook)
+	} else {
+		cmd = exec.Command("sh", "-c", hook)
 	}
 
-	// Store the port in the runner to expose in the API.
-	activeRunner.Port = port
+	cmd.Dir = server.Directory
+	cmd.Env = activeRunner.environment
 
-	// Set environment for running command, first inherit the env from the running command.
-	cmd.Env = os.Environ()
+	// Run the hook in a process group of its own to be able to kill it
+	// together with the processes it has started.
+	setProcessGroup(cmd)
 
-	// Then, add the environment variables from the config.
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PORT=%d", port))
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PATH=%s", runner.config.Servers[name].Environment["PATH"]))
+	activeRunner.appendLog("system", fmt.Sprintf("Running %s hook: %s", stage, hook))
+	serve.notifyStateChange()
 
-	// Set up pipe to read stdout
-	stdout, err := cmd.StdoutPipe()
+	wait, err := activeRunner.startCaptured(cmd, serve)
 	if err != nil {
-		return fmt.Errorf("failed to set up stdout pipe: %s", err)
+		activeRunner.appendLog("system", fmt.Sprintf("Failed to start %s hook: %s", stage, err))
+		return -1
 	}
 
-	// Set up pipe to read stderr
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("failed to set up stderr pipe: %s", err)
+	timeout := time.AfterFunc(hookTimeout, func() {
+		killProcessGroup(cmd)
+	})
+
+	wait()
+
+	if !timeout.Stop() {
+		activeRunner.appendLog("system", fmt.Sprintf("Killed the %s hook since it didn't finish within %s", stage, hookTimeout))
 	}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start process: %s", err)
+	exitCode := cmd.ProcessState.ExitCode()
+
+	if exitCode != 0 {
+		activeRunner.appendLog("system", fmt.Sprintf("The %s hook failed with exit code %d", stage, exitCode))
+		serve.notifyStateChange()
 	}
 
-	// Read stdout while the command is executed
-	stdoutScanner := bufio.NewScanner(stdout)
-	go func() {
-		for stdoutScanner.Scan() {
-			// Log it to the common log
-			activeRunner.Logs = append(activeRunner.Logs, LogEntry{
-				Timestamp: time.Now(),
-				Message:   stdoutScanner.Text(),
-				Output:    "stdout",
-			})
+	return exitCode
+}
+
+// Stop and start a server as one operation, if the server isn't
+// running it's just started.
+func (runner *Runner) Restart(name string, options RestartOptions, serve *Serve) error {
+	if _, ok := runner.config.Servers[name]; !ok {
+		return fmt.Errorf("unknown server %s", name)
+	}
+
+	var port uint
+	var logs []LogEntry
+
+	activeRunner, ok := runner.ActiveProcesses[name]
+
+	if ok && options.KeepPort {
+		port = activeRunner.Port
+	}
+
+	if err := runner.stop(name, serve); err != nil {
+		return err
+	}
+
+	// Keep the logs after the process has stopped to include the output
+	// of it shutting down and of the post_stop hooks.
+	if ok && options.KeepLogs {
+		logs = activeRunner.logs()
+	}
 
-			serve.stateChange <- true
+	runner.changeState(func() { runner.counters(name).Restarts++ })
+
+	// Add a separator between the runs in the logs.
+	if len(options.Reason) == 0 {
+		options.Reason = "Restarted"
+	}
+
+	logs = append(logs, LogEntry{
+		Timestamp: time.Now(),
+		Message:   options.Reason,
+		Output:    "system",
+	})
+
+	return runner.start(name, port, logs, serve)
+}
+
+// Move the state of a server to its new name after it has been renamed
+// in the config, a running server keeps running under the new name.
+func (runner *Runner) Rename(oldName string, newName string, serve *Serve) {
+	_, watching := runner.watchers[oldName]
+	runner.stopWatcher(oldName)
+
+	activeRunner, ok := runner.ActiveProcesses[oldName]
+
+	runner.changeState(func() {
+		if ok {
+			activeRunner.config.Name = newName
+			runner.ActiveProcesses[newName] = activeRunner
+			delete(runner.ActiveProcesses, oldName)
 		}
-	}()
 
-	// Read stderr while the command is executed
-	stderrScanner := bufio.NewScanner(stderr)
-	go func() {
-		for stderrScanner.Scan() {
-			// Log it to the common log
-			activeRunner.Logs = append(activeRunner.Logs, LogEntry{
-				Timestamp: time.Now(),
-				Message:   stderrScanner.Text(),
-				Output:    "stderr",
-			})
+		if counters, ok := runner.Counters[oldName]; ok {
+			runner.Counters[newName] = counters
+			delete(runner.Counters, oldName)
+		}
+	})
+
+	if ok && !activeRunner.hasExited() {
+		activeRunner.appendLog("system", fmt.Sprintf("Renamed from %s", oldName))
 
-			serve.stateChange <- true
+		// Watch for changes again under the new name.
+		if watching {
+			runner.startWatcher(newName, serve)
 		}
-	}()
+	}
 
-	// Store the Cmd process as an active process
-	runner.ActiveProcesses[name] = &activeRunner
+	serve.notifyStateChange()
+}
 
-	// Notify state change on start
-	serve.stateChange <- true
+// Check if the config of a running server has changed since it was
+// started.
+func (runner *Runner) ConfigChanged(name string) bool {
+	activeRunner, ok := runner.ActiveProcesses[name]
+	if !ok || activeRunner.hasExited() {
+		return false
+	}
+
+	return !activeRunner.config.Equal(runner.config.Servers[name])
+}
+
+// Get the counters for a server, creating them if needed. The caller
+// has to hold the state lock.
+func (runner *Runner) counters(name string) *RunnerCounters {
+	if _, ok := runner.Counters[name]; !ok {
+		runner.Counters[name] = &RunnerCounters{}
+	}
+
+	return runner.Counters[name]
+}
+
+// Run a task and wait for it to complete, if the task is already
+// running it waits for the running instance to complete instead.
+func (runner *Runner) runTask(name string, serve *Serve) error {
+	if task, ok := runner.config.Servers[name]; !ok || !task.IsTask() {
+		return fmt.Errorf("unknown task %s", name)
+	}
+
+	if activeRunner, ok := runner.ActiveProcesses[name]; !ok || activeRunner.hasExited() {
+		if err := runner.Start(name, serve); err != nil {
+			return err
+		}
+	}
+
+	activeRunner := runner.ActiveProcesses[name]
+
+	// Wait for the task to complete, a task that hangs would otherwise
+	// block the server from starting and all other operations.
+	select {
+	case <-activeRunner.Done:
+	case <-time.After(taskTimeout):
+		activeRunner.appendLog("system", fmt.Sprintf("Killed the task since it didn't finish within %s", taskTimeout))
+		activeRunner.Cmd.Process.Kill()
+
+		<-activeRunner.Done
+
+		return fmt.Errorf("task %s didn't finish within %s", name, taskTimeout)
+	}
+
+	if activeRunner.ExitCode != 0 {
+		return fmt.Errorf("task %s failed with exit code %d", name, activeRunner.ExitCode)
+	}
 
 	return nil
 }
@@ -185,13 +721,25 @@ func (runner *Runner) randomizePortNumber() (uint, error) {
 }
 
 func (runner *Runner) Stop(name string, serve *Serve) error {
-	// Init active processes map
-	if runner.ActiveProcesses == nil {
-		runner.ActiveProcesses = make(map[string]*ActiveRunner)
-	}
+	// Stop watching for changes since it would start the server again.
+	runner.stopWatcher(name)
 
+	return runner.stop(name, serve)
+}
+
+// Stop the process of a server without touching the file watcher.
+func (runner *Runner) stop(name string, serve *Serve) error {
 	// If server isn't running, just abort.
-	if _, ok := runner.ActiveProcesses[name]; !ok {
+	activeRunner, ok := runner.ActiveProcesses[name]
+	if !ok {
+		return nil
+	}
+
+	// If the process has exited already, just drop the old status.
+	if activeRunner.hasExited() {
+		runner.changeState(func() { delete(runner.ActiveProcesses, name) })
+		serve.notifyStateChange()
+
 		return nil
 	}
 
@@ -199,29 +747,27 @@ func (runner *Runner) Stop(name string, serve *Serve) error {
 	// we've told it to SIGTERM. If it's still running, send a SIGKILL
 	// instead to clean up.
 	go func() {
-		time.Sleep(60 * time.Second)
-
-		if _, ok := runner.ActiveProcesses[name]; ok {
+		select {
+		case <-activeRunner.Done:
+		case <-time.After(60 * time.Second):
 			log.Printf("Force killed the process since it was still alive after 60 seconds %s", name)
 
-			runner.ActiveProcesses[name].Cmd.Process.Kill()
-
-			// Delete old status for process
-			delete(runner.ActiveProcesses, name)
+			activeRunner.Cmd.Process.Kill()
 		}
 	}()
 
 	// Send SIGTERM to the process
-	runner.ActiveProcesses[name].Cmd.Process.Signal(syscall.SIGTERM)
+	runner.changeState(func() { activeRunner.stopping = true })
+	activeRunner.Cmd.Process.Signal(syscall.SIGTERM)
 
 	// Wait for process to end
-	runner.ActiveProcesses[name].Cmd.Wait()
+	<-activeRunner.Done
 
 	// Delete old status for process
-	delete(runner.ActiveProcesses, name)
+	runner.changeState(func() { delete(runner.ActiveProcesses, name) })
 
 	// Notify state change on stop
-	serve.stateChange <- true
+	serve.notifyStateChange()
 
 	return nil
 }
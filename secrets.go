@@ -0,0 +1,197 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// Value shown instead of secret values.
+	redactedValue = "********"
+
+	// Secret values shorter than this aren't masked in logs and
+	// commands since they would mask unrelated output.
+	minMaskedSecretLength = 4
+)
+
+// Check if an environment variable of the server is marked as secret.
+func (server ServerConfig) isSecret(key string) bool {
+	for _, secret := range server.SecretEnv {
+		if secret == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get the path of the secrets file of the server, relative paths are
+// relative to the directory of the server.
+func (server ServerConfig) secretsFilePath() string {
+	if len(server.SecretsFile) == 0 || filepath.IsAbs(server.SecretsFile) {
+		return server.SecretsFile
+	}
+
+	return filepath.Join(server.Directory, server.SecretsFile)
+}
+
+// Read the variables of the secrets file of the server, if it has one.
+func (server ServerConfig) loadSecrets() (map[string]string, error) {
+	if len(server.SecretsFile) == 0 {
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(server.secretsFilePath())
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSecretsFile(content)
+}
+
+// Parse a secrets file with a NAME=value variable on each line, in the
+// same format as .env files.
+func parseSecretsFile(content []byte) (map[string]string, error) {
+	secrets := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		name, value, found := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+		if !found || len(strings.TrimSpace(name)) == 0 {
+			return nil, fmt.Errorf("line %d: expected 'NAME=value'", line)
+		}
+
+		value = strings.TrimSpace(value)
+
+		// Remove matching quotes around the value.
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		secrets[strings.TrimSpace(name)] = value
+	}
+
+	return secrets, scanner.Err()
+}
+
+// Get the secret values of the server to mask, the longest values first
+// to mask values that contains other values completely.
+func (server ServerConfig) secretValues(secrets map[string]string) []string {
+	var values []string
+
+	for _, key := range server.SecretEnv {
+		if value := server.Environment[key]; len(value) >= minMaskedSecretLength {
+			values = append(values, value)
+		}
+	}
+
+	for _, value := range secrets {
+		if len(value) >= minMaskedSecretLength {
+			values = append(values, value)
+		}
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+
+	return values
+}
+
+// Replace the secret values in a message.
+func maskSecrets(message string, secrets []string) string {
+	for _, secret := range secrets {
+		message = strings.ReplaceAll(message, secret, redactedValue)
+	}
+
+	return message
+}
+
+// Get a copy of the server config with the values of secret variables
+// redacted, secret values are also masked in commands.
+func (server ServerConfig) Redacted() ServerConfig {
+	secrets, _ := server.loadSecrets()
+	values := server.secretValues(secrets)
+
+	environment := make(map[string]string, len(server.Environment))
+
+	for key, value := range server.Environment {
+		if server.isSecret(key) {
+			value = redactedValue
+		}
+
+		environment[key] = value
+	}
+
+	if server.Environment != nil {
+		server.Environment = environment
+	}
+
+	server.Command = maskSecrets(server.Command, values)
+	server.PreStart = maskCommands(server.PreStart, values)
+	server.PostStop = maskCommands(server.PostStop, values)
+
+	return server
+}
+
+// Replace the secret values in a list of commands.
+func maskCommands(commands []string, secrets []string) []string {
+	var masked []string
+
+	for _, command := range commands {
+		masked = append(masked, maskSecrets(command, secrets))
+	}
+
+	return masked
+}
+
+// Replace redacted values in an updated server config with the values
+// of the current config, to be able to update a server with a redacted
+// config without changing its secrets.
+func (server ServerConfig) restoreSecrets(current ServerConfig) ServerConfig {
+	for key, value := range server.Environment {
+		if value == redactedValue {
+			if currentValue, ok := current.Environment[key]; ok && current.isSecret(key) {
+				server.Environment[key] = currentValue
+			}
+		}
+	}
+
+	redacted := current.Redacted()
+
+	if strings.Contains(server.Command, redactedValue) && server.Command == redacted.Command {
+		server.Command = current.Command
+	}
+
+	server.PreStart = restoreCommands(server.PreStart, current.PreStart, redacted.PreStart)
+	server.PostStop = restoreCommands(server.PostStop, current.PostStop, redacted.PostStop)
+
+	return server
+}
+
+// Replace the commands that are the same as the redacted commands with
+// the current commands.
+func restoreCommands(commands []string, current []string, redacted []string) []string {
+	var restored []string
+
+	for i, command := range commands {
+		if strings.Contains(command, redactedValue) && i < len(redacted) && command == redacted[i] {
+			command = current[i]
+		}
+
+		restored = append(restored, command)
+	}
+
+	return restored
+}
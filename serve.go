@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
@@ -15,6 +17,12 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// Ways to apply a config change to a running server.
+const (
+	ApplyNextStart = "next_start"
+	ApplyRestart   = "restart"
+)
+
 const (
 	// Maximum number of log entries to send per WebSocket message to prevent timeouts
 	maxLogsPerRequest = 1000
@@ -27,14 +35,24 @@ type Serve struct {
 	clientSubscriptions map[*websocket.Conn]string      // Map of client connections and their subscriptions
 	clientOffsets       map[*websocket.Conn]uint        // Map of client offsets for pagination
 	clientLocks         map[*websocket.Conn]*sync.Mutex // Map of locks for each client connection to not send multiple messages at once
+	clientsMutex        sync.Mutex                      // Guards the client maps, never held while sending to a client
 }
 
 type ServerItem struct {
-	Name        string `json:"name"`
-	IsRunning   bool   `json:"is_running"`
-	Port        uint   `json:"port"`
-	StdoutCount uint   `json:"stdout_count"`
-	StderrCount uint   `json:"stderr_count"`
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	IsRunning     bool   `json:"is_running"`
+	HasExited     bool   `json:"has_exited"`
+	ExitCode      int    `json:"exit_code"`
+	KilledByLimit string `json:"killed_by_limit"`
+	Port          uint   `json:"port"`
+	StdoutCount   uint   `json:"stdout_count"`
+	StderrCount   uint   `json:"stderr_count"`
+	ConfigChanged bool   `json:"config_changed"`
+	ConfigFile    string `json:"config_file"`
+
+	Stats        *ProcessStats   `json:"stats"`
+	StatsHistory []ProcessSample `json:"stats_history"`
 }
 
 type ServerItemWithLogs struct {
@@ -49,7 +67,47 @@ type ServerItemList struct {
 }
 
 type ServeMessageResponse struct {
-	Message string `json:"message"`
+	Message  string           `json:"message"`
+	Errors   ValidationErrors `json:"errors,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+// Get the status code for an error of changing the config, invalid
+// configs are bad requests while failing to save them is on us.
+func errorStatus(err error) int {
+	var errs ValidationErrors
+
+	if errors.As(err, &errs) {
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+// Set the message of a response to an error, validation errors are
+// also included field by field.
+func (resp *ServeMessageResponse) setError(err error) {
+	var errs ValidationErrors
+
+	if errors.As(err, &errs) {
+		resp.Message = fmt.Sprintf("Invalid server config: %s", errs)
+		resp.Errors = errs
+
+		return
+	}
+
+	resp.Message = fmt.Sprintf("%s", err)
+}
+
+type ConfigReloadResponse struct {
+	Message string         `json:"message"`
+	Changes *ConfigChanges `json:"changes,omitempty"`
+}
+
+type ConfigImportResponse struct {
+	Message  string           `json:"message"`
+	Imported []string         `json:"imported,omitempty"`
+	Errors   ValidationErrors `json:"errors,omitempty"`
 }
 
 type ServerSubscribeMessage struct {
@@ -61,36 +119,126 @@ func NewServe(config *Config, runner *Runner) *Serve {
 	return &Serve{
 		config:              config,
 		runner:              runner,
-		stateChange:         make(chan bool),
+		stateChange:         make(chan bool, 1),
 		clientSubscriptions: make(map[*websocket.Conn]string),
 		clientOffsets:       make(map[*websocket.Conn]uint),
 		clientLocks:         make(map[*websocket.Conn]*sync.Mutex),
 	}
 }
 
+// Signal a state change without blocking, multiple state changes that
+// happen before anyone reads them are merged into one.
+func (serve *Serve) notifyStateChange() {
+	select {
+	case serve.stateChange <- true:
+	default:
+	}
+}
+
+// Wrap a handler that starts, stops or changes servers to run as the
+// only operation at a time.
+func (serve *Serve) exclusive(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serve.runner.operationMutex.Lock()
+		defer serve.runner.operationMutex.Unlock()
+
+		next(w, r)
+	}
+}
+
+// Change the config while holding the state lock, the caller has to
+// run as an exclusive operation.
+func (serve *Serve) changeConfig(change func() error) error {
+	var err error
+
+	serve.runner.changeState(func() { err = change() })
+
+	return err
+}
+
 func (serve *Serve) Run() {
+	// Make sure there's a token to authenticate API requests with.
+	generatedToken := serve.ensureToken()
+	serve.validateTokens()
+
+	serve.warnInvalidServers()
+
 	router := serve.newRouter()
 
-	log.Printf("Listening on http://%s:%d\n", serve.config.Settings.ListenAddress, serve.config.Settings.ListenPort)
+	// Sample the resource usage of the running servers.
+	go serve.runner.sampleStatsLoop(serve)
+
+	// Reload the config when it's changed outside of the API.
+	go serve.reloadConfigLoop()
+
+	switch serve.config.Settings.ListenNetwork {
+	case "unix":
+		listener, err := listenUnixSocket(serve.config.Settings.ListenSocket)
+		if err != nil {
+			log.Fatalf("Failed to listen to unix socket: %s", err)
+		}
+
+		log.Printf("Listening on unix socket %s\n", serve.config.Settings.ListenSocket)
+
+		// Listen to configured socket.
+		log.Fatal(http.Serve(listener, router))
+
+	case "tcp":
+		address := fmt.Sprintf("%s:%d", serve.config.Settings.ListenAddress, serve.config.Settings.ListenPort)
+
+		if serve.config.Settings.TLSEnabled {
+			certFile, keyFile := serve.ensureCertificate()
+
+			log.Printf("Listening on https://%s\n", address)
+			serve.logLoginURL("https://"+address, generatedToken)
+
+			// Listen to configured address and port with TLS.
+			log.Fatal(http.ListenAndServeTLS(address, certFile, keyFile, router))
+		}
 
-	// Listen to configured address and port.
-	log.Fatal(http.ListenAndServe(
-		fmt.Sprintf("%s:%d", serve.config.Settings.ListenAddress, serve.config.Settings.ListenPort),
-		router,
-	))
+		log.Printf("Listening on http://%s\n", address)
+		serve.logLoginURL("http://"+address, generatedToken)
+
+		// Listen to configured address and port.
+		log.Fatal(http.ListenAndServe(address, router))
+
+	default:
+		log.Fatalf("Unknown listen_network '%s', expected 'tcp' or 'unix'", serve.config.Settings.ListenNetwork)
+	}
 }
 
-//go:embed "static"
-var static embed.FS
+// Only warn about invalid servers in the config since they may become
+// valid later, such as when a directory on another drive is mounted.
+func (serve *Serve) warnInvalidServers() {
+	for name, server := range serve.config.Servers {
+		if errs := serve.config.ValidateServer(server); len(errs) > 0 {
+			log.Printf("Warning: Server %s in the config is invalid: %s\n", name, errs)
+		}
 
-var wsUpgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
+		for _, warning := range serve.config.duplicateEnvPorts(server) {
+			log.Printf("Warning: Server %s in the config may collide with other servers, %s\n", name, warning)
+		}
+	}
 }
 
+//go:embed "static"
+var static embed.FS
+
 func (serve *Serve) newRouter() *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
 
+	// Require authentication for all API endpoints.
+	router.Use(serve.authMiddleware)
+
+	// Reject cross-site requests.
+	router.Use(serve.csrfMiddleware)
+
+	wsUpgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     serve.isAllowedOrigin,
+	}
+
 	serveFile := func(fileName string, contentType string) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			// Look for the environment variable GOPROCMGR_ALLOW_EXTERNAL_RESOURCES
@@ -118,69 +266,302 @@ func (serve *Serve) newRouter() *mux.Router {
 	router.HandleFunc("/web/style.css", serveFile("static/style.css", "text/css"))
 	router.HandleFunc("/web/script.js", serveFile("static/script.js", "application/javascript"))
 	router.HandleFunc("/web/alpinejs-3.14.0.min.js", serveFile("static/alpinejs-3.14.0.min.js", "application/javascript"))
+	router.HandleFunc("/login", serve.loginHandler).Methods(http.MethodGet, http.MethodPost)
 
 	//
 	// Endpoints to manage the server configuration.
 	//
 
 	// Method to create new servers.
-	router.HandleFunc("/api/config/server", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/api/config/server", serve.requireRole(RoleAdmin, serve.exclusive(func(w http.ResponseWriter, r *http.Request) {
 		var server ServerConfig
 		var resp ServeMessageResponse
 
 		decoder := json.NewDecoder(r.Body)
 		err := decoder.Decode(&server)
 
+		// Keep the secrets of an existing server that are sent back
+		// redacted.
+		if current, ok := serve.config.Servers[server.Name]; ok && err == nil {
+			server = server.restoreSecrets(current)
+		}
+
+		apply, applyErr := parseApplyMode(r)
+
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			resp.Message = fmt.Sprintf("%s", err)
-		} else if err := serve.config.WriteServer(server); err != nil {
+		} else if applyErr != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			resp.Message = fmt.Sprintf("%s", err)
+			resp.Message = fmt.Sprintf("%s", applyErr)
+		} else if err := serve.changeConfig(func() error { return serve.config.WriteServer(server) }); err != nil {
+			w.WriteHeader(errorStatus(err))
+			resp.setError(err)
+		} else if err := serve.applyConfigChange(server.Name, apply); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			resp.Message = fmt.Sprintf("Saved server but failed to restart it, %s", err)
 		} else {
 			w.WriteHeader(http.StatusCreated)
 			resp.Message = "OK"
+			resp.Warnings = serve.config.duplicateEnvPorts(server)
 		}
 
-		// Stop servers on update in case it's running.
-		serve.runner.Stop(server.Name, serve)
-
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(resp)
-	}).Methods(http.MethodPost)
+	}))).Methods(http.MethodPost)
 
 	// Method to delete servers.
-	router.HandleFunc("/api/config/server/{name}", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/api/config/server/{name}", serve.requireRole(RoleAdmin, serve.exclusive(func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		var resp ServeMessageResponse
 
-		err := serve.runner.Stop(vars["name"], serve)
+		if err := serve.config.checkDeletable(vars["name"]); err != nil {
+			w.WriteHeader(errorStatus(err))
+			resp.setError(err)
+		} else if err := serve.runner.Stop(vars["name"], serve); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			resp.Message = fmt.Sprintf("Failed to stop running server %s: %s", vars["name"], err)
+		} else if err := serve.changeConfig(func() error { return serve.config.DeleteServer(vars["name"]) }); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			resp.Message = fmt.Sprintf("Failed to delete server %s: %s", vars["name"], err)
+		} else {
+			w.WriteHeader(http.StatusOK)
+			resp.Message = "OK"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))).Methods(http.MethodDelete)
+
+	// Method to fetch a single server configuration.
+	router.HandleFunc("/api/config/server/{name}", serve.requireRole(RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		serve.runner.stateMutex.Lock()
+		server, ok := serve.config.Servers[vars["name"]]
+		serve.runner.stateMutex.Unlock()
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ServeMessageResponse{Message: fmt.Sprintf("Unknown server %s", vars["name"])})
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", server.ETag())
+		json.NewEncoder(w).Encode(server.Redacted())
+	})).Methods(http.MethodGet)
+
+	// Method to replace (PUT) or partially update (PATCH) a server
+	// configuration, with an If-Match header it's only updated if it
+	// hasn't changed since it was fetched.
+	router.HandleFunc("/api/config/server/{name}", serve.requireRole(RoleAdmin, serve.exclusive(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		var resp ServeMessageResponse
+
+		current, ok := serve.config.Servers[vars["name"]]
+		apply, applyErr := parseApplyMode(r)
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if applyErr != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			resp.Message = fmt.Sprintf("%s", applyErr)
+		} else if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			resp.Message = fmt.Sprintf("Unknown server %s", vars["name"])
+		} else if ifMatch := r.Header.Get("If-Match"); len(ifMatch) > 0 && ifMatch != "*" && ifMatch != current.ETag() {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			resp.Message = fmt.Sprintf("Server %s has changed since it was fetched", vars["name"])
+		} else if server, err := decodeServerUpdate(r, current); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			resp.Message = fmt.Sprintf("%s", err)
+		} else if server.Name != current.Name {
+			w.WriteHeader(http.StatusBadRequest)
+			resp.setError(ValidationErrors{{Field: "name", Message: "can't be changed, rename the server instead"}})
+		} else if err := serve.changeConfig(func() error { return serve.config.WriteServer(server) }); err != nil {
+			w.WriteHeader(errorStatus(err))
+			resp.setError(err)
+		} else if err := serve.applyConfigChange(server.Name, apply); err != nil {
+			w.Header().Set("ETag", server.ETag())
+			w.WriteHeader(http.StatusInternalServerError)
+			resp.Message = fmt.Sprintf("Saved server but failed to restart it, %s", err)
+		} else {
+			w.Header().Set("ETag", server.ETag())
+			w.WriteHeader(http.StatusOK)
+			resp.Message = "OK"
+			resp.Warnings = serve.config.duplicateEnvPorts(server)
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}))).Methods(http.MethodPut, http.MethodPatch)
+
+	// Method to rename a server, a running server keeps running under
+	// the new name.
+	router.HandleFunc("/api/config/server/{name}/rename", serve.requireRole(RoleAdmin, serve.exclusive(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		var resp ServeMessageResponse
+		var rename struct {
+			Name string `json:"name"`
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewDecoder(r.Body).Decode(&rename); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			resp.Message = fmt.Sprintf("%s", err)
+		} else if _, ok := serve.config.Servers[vars["name"]]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			resp.Message = fmt.Sprintf("Unknown server %s", vars["name"])
+		} else if err := serve.changeConfig(func() error { return serve.config.RenameServer(vars["name"], rename.Name) }); err != nil {
+			w.WriteHeader(errorStatus(err))
+			resp.setError(err)
+		} else {
+			serve.runner.Rename(vars["name"], rename.Name, serve)
+
+			w.WriteHeader(http.StatusOK)
+			resp.Message = "OK"
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}))).Methods(http.MethodPost)
+
+	// Method to reload the config file from disk.
+	router.HandleFunc("/api/config/reload", serve.requireRole(RoleAdmin, serve.exclusive(func(w http.ResponseWriter, r *http.Request) {
+		var resp ConfigReloadResponse
+
+		changes, err := serve.reloadConfig()
+
+		w.Header().Set("Content-Type", "application/json")
 
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			resp.Message = fmt.Sprintf("Failed to stop running server %s: %s", vars["name"], err)
+			resp.Message = fmt.Sprintf("Failed to reload config, keeping the current config: %s", err)
+		} else {
+			w.WriteHeader(http.StatusOK)
+			resp.Message = "OK"
+			resp.Changes = &changes
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}))).Methods(http.MethodPost)
+
+	// Method to register a project directory to include its config file
+	// and Procfile.
+	router.HandleFunc("/api/config/project", serve.requireRole(RoleAdmin, serve.exclusive(func(w http.ResponseWriter, r *http.Request) {
+		var resp ConfigReloadResponse
+		var project struct {
+			Directory string `json:"dir"`
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewDecoder(r.Body).Decode(&project); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			resp.Message = fmt.Sprintf("Failed to parse project: %s", err)
+		} else if err := serve.changeConfig(func() error { return serve.config.AddProject(project.Directory) }); err != nil {
+			w.WriteHeader(errorStatus(err))
+			resp.Message = fmt.Sprintf("Failed to add project: %s", err)
+		} else if changes, err := serve.reloadConfig(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			resp.Message = fmt.Sprintf("Added project but failed to reload config: %s", err)
 		} else {
 			w.WriteHeader(http.StatusOK)
 			resp.Message = "OK"
-			serve.config.DeleteServer(vars["name"])
+			resp.Changes = &changes
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}))).Methods(http.MethodPost)
+
+	// Method to export servers to a bundle to share with others.
+	router.HandleFunc("/api/config/export", serve.requireRole(RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		serve.runner.stateMutex.Lock()
+		bundle, err := serve.config.ExportBundle(r.URL.Query()["server"], r.URL.Query().Get("base_dir"))
+		serve.runner.stateMutex.Unlock()
+		if err != nil {
+			var resp ServeMessageResponse
+			resp.setError(err)
+
+			w.WriteHeader(errorStatus(err))
+			json.NewEncoder(w).Encode(resp)
+
+			return
 		}
 
+		json.NewEncoder(w).Encode(bundle)
+	})).Methods(http.MethodGet)
+
+	// Method to import servers from a bundle.
+	router.HandleFunc("/api/config/import", serve.requireRole(RoleAdmin, serve.exclusive(func(w http.ResponseWriter, r *http.Request) {
+		var resp ConfigImportResponse
+		var bundle ConfigBundle
+
 		w.Header().Set("Content-Type", "application/json")
+
+		var overwrite bool
+		var overwriteErr error
+
+		if value := r.URL.Query().Get("overwrite"); len(value) > 0 {
+			overwrite, overwriteErr = strconv.ParseBool(value)
+		}
+
+		var imported []string
+		importBundle := func() (err error) {
+			imported, err = serve.config.ImportBundle(bundle, r.URL.Query().Get("base_dir"), overwrite)
+			return err
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			resp.Message = fmt.Sprintf("Failed to parse bundle: %s", err)
+		} else if overwriteErr != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			resp.Message = "'overwrite' has to be a boolean"
+		} else if err := serve.changeConfig(importBundle); err != nil {
+			var errs ValidationErrors
+
+			w.WriteHeader(errorStatus(err))
+			resp.Message = fmt.Sprintf("Failed to import bundle: %s", err)
+
+			if errors.As(err, &errs) {
+				resp.Message = "Failed to import bundle, no servers were imported"
+				resp.Errors = errs
+			}
+		} else {
+			w.WriteHeader(http.StatusOK)
+			resp.Message = "OK"
+			resp.Imported = imported
+
+			serve.notifyStateChange()
+		}
+
 		json.NewEncoder(w).Encode(resp)
-	}).Methods(http.MethodDelete)
+	}))).Methods(http.MethodPost)
 
 	// Method to fetch all servers configurations
-	router.HandleFunc("/api/config/server", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/api/config/server", serve.requireRole(RoleViewer, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(serve.config.Servers)
-	}).Methods(http.MethodGet)
+
+		serve.runner.stateMutex.Lock()
+		servers := make(map[string]ServerConfig, len(serve.config.Servers))
+		for name, server := range serve.config.Servers {
+			servers[name] = server.Redacted()
+		}
+		serve.runner.stateMutex.Unlock()
+
+		json.NewEncoder(w).Encode(servers)
+	})).Methods(http.MethodGet)
 
 	//
 	// Endpoints to manage running state of servers
 	//
 
 	// Endpoint to start a server
-	router.HandleFunc("/api/runner/{name}", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/api/runner/{name}", serve.requireRole(RoleOperator, serve.exclusive(func(w http.ResponseWriter, r *http.Request) {
 		var resp ServeMessageResponse
 		vars := mux.Vars(r)
 
@@ -196,10 +577,10 @@ func (serve *Serve) newRouter() *mux.Router {
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(resp)
-	}).Methods(http.MethodPost)
+	}))).Methods(http.MethodPost)
 
 	// Endpoint to stop a server
-	router.HandleFunc("/api/runner/{name}", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/api/runner/{name}", serve.requireRole(RoleOperator, serve.exclusive(func(w http.ResponseWriter, r *http.Request) {
 		var resp ServeMessageResponse
 		vars := mux.Vars(r)
 
@@ -215,30 +596,68 @@ func (serve *Serve) newRouter() *mux.Router {
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(resp)
-	}).Methods(http.MethodDelete)
+	}))).Methods(http.MethodDelete)
+
+	// Endpoint to restart a server
+	router.HandleFunc("/api/runner/{name}/restart", serve.requireRole(RoleOperator, serve.exclusive(func(w http.ResponseWriter, r *http.Request) {
+		var resp ServeMessageResponse
+		vars := mux.Vars(r)
+
+		options, err := parseRestartOptions(r)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			resp.Message = fmt.Sprintf("Invalid restart options, %s", err)
+		} else if err := serve.runner.Restart(vars["name"], options, serve); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			resp.Message = fmt.Sprintf("Failed to restart server %s, %s", vars["name"], err)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+			resp.Message = "OK"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))).Methods(http.MethodPost)
+
+	//
+	// Endpoint to fetch the identity and role of the current token
+	//
+	router.HandleFunc("/api/whoami", serve.requireRole(RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(requestIdentity(r))
+	})).Methods(http.MethodGet)
 
 	//
 	// Endpoint to fetch an overview of the state of all servers
 	//
 
 	// Fetch state without logs for all servers
-	router.HandleFunc("/api/state", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/api/state", serve.requireRole(RoleViewer, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(serve.GetServerList())
-	}).Methods(http.MethodGet)
+	})).Methods(http.MethodGet)
 
 	// Fetch state and logs for a single server
-	router.HandleFunc("/api/state/{name}", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/api/state/{name}", serve.requireRole(RoleViewer, func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(serve.GetServerLogs(vars["name"]))
-	}).Methods(http.MethodGet)
+	})).Methods(http.MethodGet)
+
+	//
+	// Endpoint to expose metrics of all servers for Prometheus
+	//
+	router.HandleFunc("/metrics", serve.requireRole(RoleViewer, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		serve.WriteMetrics(w)
+	})).Methods(http.MethodGet)
 
 	//
 	// Websocket endpoint to stream the state of the runner
 	//
-	router.HandleFunc("/api/ws", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/api/ws", serve.requireRole(RoleViewer, func(w http.ResponseWriter, r *http.Request) {
 		conn, err := wsUpgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.Println("Upgrade:", err)
@@ -246,16 +665,12 @@ func (serve *Serve) newRouter() *mux.Router {
 		}
 		defer conn.Close()
 
-		serve.clientSubscriptions[conn] = ""    // Initialize with no subscription
-		serve.clientOffsets[conn] = 0           // Initialize offset to 0
-		serve.clientLocks[conn] = &sync.Mutex{} // Initialize mutex for this connection
+		serve.addClient(conn)
 
 		go func() {
 			defer func() {
 				conn.Close()
-				delete(serve.clientSubscriptions, conn)
-				delete(serve.clientOffsets, conn)
-				delete(serve.clientLocks, conn) // Remove mutex for this connection
+				serve.removeClient(conn)
 			}()
 
 			// Send initial list state on connect to the client
@@ -276,8 +691,7 @@ func (serve *Serve) newRouter() *mux.Router {
 					continue
 				}
 
-				serve.clientSubscriptions[conn] = subscription.Name
-				serve.clientOffsets[conn] = subscription.Offset
+				serve.subscribeClient(conn, subscription.Name, subscription.Offset)
 
 				// Send initial state for the subscribed server
 				serverState := serve.GetServerLogsWithOffset(subscription.Name, subscription.Offset)
@@ -286,10 +700,10 @@ func (serve *Serve) newRouter() *mux.Router {
 				if len(serverState.Logs) > 0 {
 					// Calculate new offset before sending
 					newOffset := serverState.Offset + uint(len(serverState.Logs))
-					
+
 					// Only update offset if send was successful
 					if serve.sendMessageAndUpdateOffset(conn, serverState, newOffset) {
-						serve.clientOffsets[conn] = newOffset
+						serve.setClientOffset(conn, newOffset)
 					}
 				}
 
@@ -309,7 +723,7 @@ func (serve *Serve) newRouter() *mux.Router {
 			// Store the last send time
 			lastSend = time.Now().UnixNano() / int64(time.Millisecond)
 
-			for client, name := range serve.clientSubscriptions {
+			for client, name := range serve.clientSubscriptionsCopy() {
 				// Send the list state regardless of subscription
 				listState := serve.GetServerList()
 				serve.sendMessage(client, listState)
@@ -320,7 +734,10 @@ func (serve *Serve) newRouter() *mux.Router {
 				}
 
 				// Get the current offset for this client
-				offset := serve.clientOffsets[client]
+				offset, ok := serve.clientOffset(client)
+				if !ok {
+					continue
+				}
 
 				// Send state for the subscribed server starting from the offset
 				serverState := serve.GetServerLogsWithOffset(name, offset)
@@ -328,19 +745,106 @@ func (serve *Serve) newRouter() *mux.Router {
 				// Send state even if no new logs, so client can detect server stop/restart
 				// Calculate new offset before sending
 				newOffset := serverState.Offset + uint(len(serverState.Logs))
-				
+
 				// Only update offset if send was successful
 				if serve.sendMessageAndUpdateOffset(client, serverState, newOffset) {
-					serve.clientOffsets[client] = newOffset
+					serve.setClientOffset(client, newOffset)
 				}
 			}
 		}
-	}).Methods(http.MethodGet)
+	})).Methods(http.MethodGet)
 
 	return router
 }
 
+// Parse the restart options from the query parameters of a request,
+// both the port and the logs are kept by default.
+func parseRestartOptions(r *http.Request) (RestartOptions, error) {
+	options := RestartOptions{KeepPort: true, KeepLogs: true}
+
+	for param, option := range map[string]*bool{"keep_port": &options.KeepPort, "keep_logs": &options.KeepLogs} {
+		if value := r.URL.Query().Get(param); len(value) > 0 {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return options, fmt.Errorf("'%s' has to be a boolean", param)
+			}
+
+			*option = parsed
+		}
+	}
+
+	return options, nil
+}
+
+// Parse how to apply a config change from the query parameters of a
+// request, by default changes are applied on the next start.
+func parseApplyMode(r *http.Request) (string, error) {
+	apply := r.URL.Query().Get("apply")
+
+	switch apply {
+	case "":
+		return ApplyNextStart, nil
+	case ApplyNextStart, ApplyRestart:
+		return apply, nil
+	}
+
+	return apply, fmt.Errorf("'apply' has to be either '%s' or '%s'", ApplyNextStart, ApplyRestart)
+}
+
+// Apply a config change to a server, the server is only restarted if
+// asked to and if it's running with a config that differs from the
+// new config. Otherwise it keeps running until it's started again.
+func (serve *Serve) applyConfigChange(name string, apply string) error {
+	if apply == ApplyRestart && serve.runner.ConfigChanged(name) {
+		return serve.runner.Restart(name, RestartOptions{KeepPort: true, KeepLogs: true, Reason: "Restarted to apply config changes"}, serve)
+	}
+
+	// Update clients about the config being changed since start.
+	serve.notifyStateChange()
+
+	return nil
+}
+
+// Decode the server config of a PUT request, or apply the JSON merge
+// patch of a PATCH request to the current server config. The name
+// defaults to the current name.
+func decodeServerUpdate(r *http.Request, current ServerConfig) (ServerConfig, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return current, err
+	}
+
+	if r.Method == http.MethodPatch {
+		server, err := current.Patch(body)
+		if err != nil {
+			return current, err
+		}
+
+		return server.restoreSecrets(current), nil
+	}
+
+	var server ServerConfig
+	if err := json.Unmarshal(body, &server); err != nil {
+		return current, err
+	}
+
+	if len(server.Name) == 0 {
+		server.Name = current.Name
+	}
+
+	// Keep the secrets that are sent back redacted.
+	return server.restoreSecrets(current), nil
+}
+
 func (serve *Serve) GetServer(name string) (ServerItem, error) {
+	serve.runner.stateMutex.Lock()
+	defer serve.runner.stateMutex.Unlock()
+
+	return serve.getServer(name)
+}
+
+// Get the state of a server, the caller has to hold the state lock.
+func (serve *Serve) getServer(name string) (ServerItem, error) {
 	var serverItem ServerItem
 
 	// Check if name is a valid entry in serve.config.Servers, if
@@ -350,12 +854,27 @@ func (serve *Serve) GetServer(name string) (ServerItem, error) {
 	}
 
 	serverItem.Name = name
+	serverItem.Type = ServerTypeServer
+	serverItem.ConfigFile = serve.config.ServerFileName(name)
 	serverItem.IsRunning = false
 
+	if serve.config.Servers[name].IsTask() {
+		serverItem.Type = ServerTypeTask
+	}
+
 	if serve.runner.ActiveProcesses[name] != nil {
-		serverItem.IsRunning = true
+		serverItem.IsRunning = !serve.runner.ActiveProcesses[name].Exited
+		serverItem.HasExited = serve.runner.ActiveProcesses[name].Exited
+		serverItem.ExitCode = serve.runner.ActiveProcesses[name].ExitCode
+		serverItem.KilledByLimit = serve.runner.ActiveProcesses[name].KilledByLimit
 		serverItem.Port = serve.runner.ActiveProcesses[name].Port
 
+		if serverItem.IsRunning {
+			serverItem.ConfigChanged = serve.runner.ConfigChanged(name)
+			serverItem.Stats = serve.runner.ActiveProcesses[name].Stats
+			serverItem.StatsHistory = serve.runner.ActiveProcesses[name].StatsHistory
+		}
+
 		// Count the logs for each server by output
 		for _, logEntry := range serve.runner.ActiveProcesses[name].Logs {
 			if logEntry.Output == "stdout" {
@@ -370,13 +889,16 @@ func (serve *Serve) GetServer(name string) (ServerItem, error) {
 }
 
 func (serve *Serve) GetServerList() ServerItemList {
+	serve.runner.stateMutex.Lock()
+	defer serve.runner.stateMutex.Unlock()
+
 	servers := ServerItemList{
 		Servers: make(map[string]ServerItem),
 	}
 
 	// Go through all configured servers
 	for serverName := range serve.config.Servers {
-		server, err := serve.GetServer(serverName)
+		server, err := serve.getServer(serverName)
 
 		if err != nil {
 			log.Println(err)
@@ -395,12 +917,17 @@ func (serve *Serve) GetServerLogs(name string) ServerItemWithLogs {
 }
 
 func (serve *Serve) GetServerLogsWithOffset(name string, offset uint) ServerItemWithLogs {
+	serve.runner.stateMutex.Lock()
+	defer serve.runner.stateMutex.Unlock()
+
 	var serverItemWithLogs ServerItemWithLogs
 
-	serverItemWithLogs.ServerItem, _ = serve.GetServer(name)
+	serverItemWithLogs.ServerItem, _ = serve.getServer(name)
 	serverItemWithLogs.Offset = offset
 
-	if serverItemWithLogs.ServerItem.IsRunning {
+	// Logs are kept for processes that has exited until they're
+	// started again or stopped.
+	if serve.runner.ActiveProcesses[name] != nil {
 		allLogs := serve.runner.ActiveProcesses[name].Logs
 		serverItemWithLogs.TotalCount = uint(len(allLogs))
 
@@ -422,45 +949,119 @@ func (serve *Serve) GetServerLogsWithOffset(name string, offset uint) ServerItem
 	return serverItemWithLogs
 }
 
-// Send a message to a client over a websocket connection
-func (serve *Serve) sendMessage(client *websocket.Conn, data interface{}) {
-	message, err := json.Marshal(data)
-	if err != nil {
-		log.Println("Marshal:", err)
-		return
+// Add a connected client with no subscription.
+func (serve *Serve) addClient(client *websocket.Conn) {
+	serve.clientsMutex.Lock()
+	defer serve.clientsMutex.Unlock()
+
+	serve.clientSubscriptions[client] = ""
+	serve.clientOffsets[client] = 0
+	serve.clientLocks[client] = &sync.Mutex{}
+}
+
+// Remove a client that has disconnected.
+func (serve *Serve) removeClient(client *websocket.Conn) {
+	serve.clientsMutex.Lock()
+	defer serve.clientsMutex.Unlock()
+
+	delete(serve.clientSubscriptions, client)
+	delete(serve.clientOffsets, client)
+	delete(serve.clientLocks, client)
+}
+
+// Subscribe a client to the logs of a server from an offset.
+func (serve *Serve) subscribeClient(client *websocket.Conn, name string, offset uint) {
+	serve.clientsMutex.Lock()
+	defer serve.clientsMutex.Unlock()
+
+	if _, ok := serve.clientLocks[client]; ok {
+		serve.clientSubscriptions[client] = name
+		serve.clientOffsets[client] = offset
 	}
+}
 
-	serve.clientLocks[client].Lock()
-	defer serve.clientLocks[client].Unlock()
+// Get a copy of the subscriptions of all clients to send to them
+// without holding the lock.
+func (serve *Serve) clientSubscriptionsCopy() map[*websocket.Conn]string {
+	serve.clientsMutex.Lock()
+	defer serve.clientsMutex.Unlock()
 
-	if err := client.WriteMessage(websocket.TextMessage, message); err != nil {
-		log.Println("WriteMessage:", err)
-		client.Close()
-		delete(serve.clientSubscriptions, client)
-		delete(serve.clientOffsets, client)
-		delete(serve.clientLocks, client)
+	subscriptions := make(map[*websocket.Conn]string, len(serve.clientSubscriptions))
+	for client, name := range serve.clientSubscriptions {
+		subscriptions[client] = name
+	}
+
+	return subscriptions
+}
+
+// Get the number of connected clients.
+func (serve *Serve) clientCount() int {
+	serve.clientsMutex.Lock()
+	defer serve.clientsMutex.Unlock()
+
+	return len(serve.clientSubscriptions)
+}
+
+// Get the log offset of a client, false if it has disconnected.
+func (serve *Serve) clientOffset(client *websocket.Conn) (uint, bool) {
+	serve.clientsMutex.Lock()
+	defer serve.clientsMutex.Unlock()
+
+	offset, ok := serve.clientOffsets[client]
+
+	return offset, ok
+}
+
+// Set the log offset of a client if it's still connected.
+func (serve *Serve) setClientOffset(client *websocket.Conn, offset uint) {
+	serve.clientsMutex.Lock()
+	defer serve.clientsMutex.Unlock()
+
+	if _, ok := serve.clientOffsets[client]; ok {
+		serve.clientOffsets[client] = offset
 	}
 }
 
+// Get the lock to send to a client, nil if it has disconnected.
+func (serve *Serve) clientLock(client *websocket.Conn) *sync.Mutex {
+	serve.clientsMutex.Lock()
+	defer serve.clientsMutex.Unlock()
+
+	return serve.clientLocks[client]
+}
+
+// Send a message to a client over a websocket connection
+func (serve *Serve) sendMessage(client *websocket.Conn, data interface{}) {
+	serve.writeMessage(client, data)
+}
+
 // Send a message and update offset only if successful
 func (serve *Serve) sendMessageAndUpdateOffset(client *websocket.Conn, data interface{}, newOffset uint) bool {
+	return serve.writeMessage(client, data)
+}
+
+// Write a message to a client, the client is removed if it fails.
+func (serve *Serve) writeMessage(client *websocket.Conn, data interface{}) bool {
 	message, err := json.Marshal(data)
 	if err != nil {
 		log.Println("Marshal:", err)
 		return false
 	}
 
-	serve.clientLocks[client].Lock()
-	defer serve.clientLocks[client].Unlock()
+	lock := serve.clientLock(client)
+	if lock == nil {
+		return false
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
 
 	if err := client.WriteMessage(websocket.TextMessage, message); err != nil {
 		log.Println("WriteMessage:", err)
 		client.Close()
-		delete(serve.clientSubscriptions, client)
-		delete(serve.clientOffsets, client)
-		delete(serve.clientLocks, client)
+		serve.removeClient(client)
 		return false
 	}
-	
+
 	return true
 }
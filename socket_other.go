@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"fmt"
+	"net"
+)
+
+func listenUnixSocket(socketPath string) (net.Listener, error) {
+	return nil, fmt.Errorf("unix sockets are not supported on this platform")
+}
+
+func checkSocketPeer(conn net.Conn, socketPath string) error {
+	return fmt.Errorf("unix sockets are not supported on this platform")
+}
@@ -0,0 +1,89 @@
+//go:build unix
+
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Listen to a unix socket that only the current user can access. A
+// socket left behind by a previous instance is replaced, but not one
+// that is in use.
+func listenUnixSocket(socketPath string) (net.Listener, error) {
+	if err := ensureSocketDirectory(filepath.Dir(socketPath)); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(socketPath); err == nil {
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is already in use", socketPath)
+		}
+
+		if err := os.Remove(socketPath); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create the socket without permissions for anyone else to not
+	// have a window where others may connect.
+	oldUmask := syscall.Umask(0077)
+	listener, err := net.Listen("unix", socketPath)
+	syscall.Umask(oldUmask)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// Create the directory of a socket if it's missing, only accessible by
+// the current user. Others must not be able to replace the socket, so an
+// existing directory has to be owned by the current user or be a sticky
+// directory owned by root such as /tmp.
+func ensureSocketDirectory(directory string) error {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(directory)
+	if err != nil {
+		return err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("failed to read the owner of %s", directory)
+	}
+
+	if int(stat.Uid) == os.Getuid() || (stat.Uid == 0 && info.Mode()&os.ModeSticky != 0) {
+		return nil
+	}
+
+	return fmt.Errorf("directory %s of the socket is owned by another user", directory)
+}
+
+// Check that a connected unix socket is served by the current user, to
+// not send the token to a socket that someone else has created.
+func checkSocketPeer(conn net.Conn, socketPath string) error {
+	uid, err := socketPeerUID(conn, socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to check the owner of socket %s: %s", socketPath, err)
+	}
+
+	if uid != os.Getuid() {
+		return fmt.Errorf("socket %s is served by another user (uid %d)", socketPath, uid)
+	}
+
+	return nil
+}
@@ -22,9 +22,18 @@ document.addEventListener('alpine:init', () => {
         // The WebSocket connection, this is used to get data from the server.
         ws: null,
 
+        // Set when the API rejects us, this is used to show the login form.
+        unauthorized: false,
+
+        // The role of our token, this is used to hide controls we can't use.
+        role: null,
+
         // Allow auto scrolling
         autoScroll: true,
 
+        // The form to create or edit a server config, null when it's closed.
+        serverForm: null,
+
         // Initialize the application
         init() {
             // Setup the WebSocket connection to get data from the server.
@@ -61,10 +70,13 @@ document.addEventListener('alpine:init', () => {
         // Setup the WebSocket connection to get data from the server.
         setupWebSocket() {
             // Create a new WebSocket connection to the server.
-            this.ws = new WebSocket(`ws://${window.location.host}/api/ws`)
+            const protocol = window.location.protocol === 'https:' ? 'wss' : 'ws'
+            this.ws = new WebSocket(`${protocol}://${window.location.host}/api/ws`)
 
             // On open, subscribe to the currently selected server.
             this.ws.onopen = () => {
+                this.unauthorized = false
+                this.fetchIdentity()
                 this.subscribeToServer(this.selectedServer)
             }
 
@@ -136,12 +148,229 @@ document.addEventListener('alpine:init', () => {
                 this.previousStdoutCount = 0 // Reset count tracking on reconnect
                 this.previousStderrCount = 0 // Reset count tracking on reconnect
 
+                // Check if the connection was closed due to missing authentication.
+                this.checkAuthorization()
+
                 setTimeout(() => {
                     this.setupWebSocket()
                 }, 1000)
             }
         },
 
+        // Check if the API accepts our credentials to show the login form if it doesn't.
+        async checkAuthorization() {
+            try {
+                const response = await fetch('/api/state')
+                this.unauthorized = response.status === 401
+            } catch (_) {
+                // The server is unreachable, keep trying to reconnect.
+            }
+        },
+
+        // Fetch the role of our token.
+        async fetchIdentity() {
+            try {
+                const response = await fetch('/api/whoami')
+                this.role = response.ok ? (await response.json()).role : null
+            } catch (_) {
+                this.role = null
+            }
+        },
+
+        // Check if our token is allowed to start, stop and restart servers.
+        canOperate() {
+            return this.role === 'operator' || this.role === 'admin'
+        },
+
+        // Check if our token is allowed to change the server configs.
+        isAdmin() {
+            return this.role === 'admin'
+        },
+
+        // Build the state of the server form from a server config, the
+        // original name and ETag are set when editing an existing server.
+        newServerForm(config, originalName = null, etag = null) {
+            const lines = (list) => (list || []).join('\n')
+
+            return {
+                originalName: originalName,
+                etag: etag,
+                base: config,
+                name: config.name || '',
+                type: config.type || 'server',
+                cwd: config.cwd || '',
+                cmd: config.cmd || '',
+                use_direnv: config.use_direnv || false,
+                use_shell: config.use_shell || false,
+                env: Object.entries(config.env || {}).map(([key, value]) => ({ key, value, secret: (config.secret_env || []).includes(key) })),
+                secrets_file: config.secrets_file || '',
+                pre_start_tasks: lines(config.pre_start_tasks),
+                pre_start: lines(config.pre_start),
+                post_stop: lines(config.post_stop),
+                watch: lines(config.watch),
+                watch_ignore: lines(config.watch_ignore),
+                memory_limit: config.memory_limit || '',
+                cpu_limit: config.cpu_limit || '',
+                nofile: config.nofile || '',
+                nice: config.nice || '',
+                errors: {},
+                error: '',
+            }
+        },
+
+        // Open the form to create a new server.
+        openCreateForm() {
+            this.serverForm = this.newServerForm({})
+        },
+
+        // Open the form to edit an existing server.
+        async openEditForm(name) {
+            const response = await fetch(`/api/config/server/${encodeURIComponent(name)}`)
+
+            if (response.ok) {
+                this.serverForm = this.newServerForm(await response.json(), name, response.headers.get('ETag'))
+            }
+        },
+
+        // Build the server config from the server form, fields the form
+        // doesn't know about are kept from the original config.
+        serverFormConfig() {
+            const form = this.serverForm
+            const lines = (text) => {
+                const list = text.split('\n').map(line => line.trim()).filter(line => line !== '')
+                return list.length > 0 ? list : undefined
+            }
+            const number = (value) => value === '' ? undefined : Number(value)
+
+            return {
+                ...form.base,
+                name: form.name.trim(),
+                type: form.type,
+                cwd: form.cwd,
+                cmd: form.cmd,
+                use_direnv: form.use_direnv,
+                use_shell: form.use_shell || undefined,
+                env: Object.fromEntries(form.env.filter(row => row.key !== '').map(row => [row.key, row.value])),
+                secret_env: lines(form.env.filter(row => row.key !== '' && row.secret).map(row => row.key).join('\n')),
+                secrets_file: form.secrets_file || undefined,
+                pre_start_tasks: lines(form.pre_start_tasks),
+                pre_start: lines(form.pre_start),
+                post_stop: lines(form.post_stop),
+                watch: lines(form.watch),
+                watch_ignore: lines(form.watch_ignore),
+                memory_limit: form.memory_limit || undefined,
+                cpu_limit: number(form.cpu_limit),
+                nofile: number(form.nofile),
+                nice: number(form.nice),
+            }
+        },
+
+        // Show an error response from the API in the server form, errors
+        // about a specific field are shown next to that field.
+        setServerFormError(response, field = null) {
+            if (response.errors) {
+                this.serverForm.errors = response.errors.reduce((errors, error) => {
+                    errors[error.field] = errors[error.field] ? `${errors[error.field]}, ${error.message}` : error.message
+                    return errors
+                }, {})
+            } else if (field) {
+                this.serverForm.errors = { [field]: response.message }
+            } else {
+                this.serverForm.error = response.message
+            }
+        },
+
+        // Save the server form, apply is either 'next_start' or 'restart'.
+        async saveServerForm(apply) {
+            const form = this.serverForm
+            const config = this.serverFormConfig()
+            const headers = { 'Content-Type': 'application/json', 'X-Goprocmgr-Csrf': '1' }
+
+            form.errors = {}
+            form.error = ''
+
+            if (form.originalName === null) {
+                if (this.getServer(config.name).name) {
+                    this.setServerFormError({ message: `server ${config.name} already exists` }, 'name')
+                    return
+                }
+
+                const response = await fetch(`/api/config/server?apply=${apply}`, {
+                    method: 'POST',
+                    headers: headers,
+                    body: JSON.stringify(config),
+                })
+
+                if (!response.ok) {
+                    this.setServerFormError(await response.json())
+                    return
+                }
+            } else {
+                const response = await fetch(`/api/config/server/${encodeURIComponent(form.originalName)}?apply=${apply}`, {
+                    method: 'PUT',
+                    headers: { ...headers, 'If-Match': form.etag },
+                    body: JSON.stringify({ ...config, name: form.originalName }),
+                })
+
+                if (response.status === 412) {
+                    this.setServerFormError({ message: 'The server has been changed by someone else since the form was opened, reopen the form to edit the latest version.' })
+                    return
+                }
+
+                if (!response.ok) {
+                    this.setServerFormError(await response.json())
+                    return
+                }
+
+                form.etag = response.headers.get('ETag')
+
+                // Rename the server after the rest of the changes are saved.
+                if (config.name !== form.originalName) {
+                    const renameResponse = await fetch(`/api/config/server/${encodeURIComponent(form.originalName)}/rename`, {
+                        method: 'POST',
+                        headers: headers,
+                        body: JSON.stringify({ name: config.name }),
+                    })
+
+                    if (!renameResponse.ok) {
+                        this.setServerFormError(await renameResponse.json(), 'name')
+                        return
+                    }
+
+                    if (this.selectedServer === form.originalName) {
+                        this.selectedServer = config.name
+                    }
+                }
+            }
+
+            this.serverForm = null
+        },
+
+        // Delete the server of the server form after confirming it.
+        async deleteServerForm() {
+            const name = this.serverForm.originalName
+
+            if (!confirm(`Delete the server ${name}? A running server is stopped.`)) {
+                return
+            }
+
+            const response = await fetch(`/api/config/server/${encodeURIComponent(name)}`, {
+                method: 'DELETE',
+                headers: { 'X-Goprocmgr-Csrf': '1' },
+            })
+
+            if (!response.ok) {
+                this.setServerFormError(await response.json())
+                return
+            }
+
+            if (this.selectedServer === name) {
+                this.selectedServer = null
+            }
+
+            this.serverForm = null
+        },
+
         // Method to check scroll position to enable or disable autoScroll
         checkScrollPosition() {
             const logsWrapper = this.$refs.logsWrapper
@@ -180,6 +409,15 @@ document.addEventListener('alpine:init', () => {
         async toggleServer(name) {
             await fetch(`/api/runner/${name}`, {
                 method: this.getServer(name).is_running ? 'DELETE' : 'POST',
+                headers: { 'X-Goprocmgr-Csrf': '1' },
+            })
+        },
+
+        // Restart the server, keeping the port and the logs.
+        async restartServer(name) {
+            await fetch(`/api/runner/${name}/restart`, {
+                method: 'POST',
+                headers: { 'X-Goprocmgr-Csrf': '1' },
             })
         },
 
@@ -188,6 +426,51 @@ document.addEventListener('alpine:init', () => {
             return this.serverList.find(item => item.name === name) || {}
         },
 
+        // Describe how a task or server exited
+        exitStatusText(server) {
+            if (server.killed_by_limit) {
+                return `Killed by ${server.killed_by_limit} (exit code ${server.exit_code})`
+            }
+
+            if (server.type === 'task') {
+                return server.exit_code === 0
+                    ? 'Task completed successfully (exit code 0)'
+                    : `Task failed with exit code ${server.exit_code}`
+            }
+
+            return `Server exited with exit code ${server.exit_code}`
+        },
+
+        // Build the points of a sparkline for a value in the stats history,
+        // scaled to fit a 60x20 view box.
+        sparklinePoints(history, key) {
+            if (!history || history.length === 0) {
+                return ''
+            }
+
+            const max = Math.max(...history.map(sample => sample[key]), key === 'cpu_percent' ? 100 : 1)
+
+            return history.map((sample, idx) => {
+                const x = history.length === 1 ? 60 : (idx / (history.length - 1)) * 60
+                const y = 20 - (sample[key] / max) * 20
+
+                return `${x.toFixed(1)},${y.toFixed(1)}`
+            }).join(' ')
+        },
+
+        // Format a number of bytes in a human readable way
+        formatBytes(bytes) {
+            const units = ['B', 'KiB', 'MiB', 'GiB', 'TiB']
+            let unit = 0
+
+            while (bytes >= 1024 && unit < units.length - 1) {
+                bytes /= 1024
+                unit++
+            }
+
+            return `${bytes.toFixed(1)} ${units[unit]}`
+        },
+
         // Format a timestamp to HH:MM:SS
         formatTimestamp(timestamp) {
             return new Date(timestamp).toLocaleTimeString([], {
@@ -207,6 +490,15 @@ document.addEventListener('alpine:init', () => {
                 this.keyEventHandled = false
             }, 100)
 
+            // Don't handle keybinds while typing in the server form.
+            if (this.serverForm) {
+                if (this.keyEvent.key === 'Escape') {
+                    this.serverForm = null
+                }
+
+                return
+            }
+
             if (this.keyEvent.key === 'Escape') {
                 this.selectedServer = null
             }
@@ -215,10 +507,14 @@ document.addEventListener('alpine:init', () => {
                 this.scrollToBottom()
             }
 
-            if (this.keyEvent.key === 't' && this.selectedServer) {
+            if (this.keyEvent.key === 't' && this.selectedServer && this.canOperate()) {
                 this.toggleServer(this.selectedServer)
             }
 
+            if (this.keyEvent.key === 'r' && this.selectedServer && this.canOperate()) {
+                this.restartServer(this.selectedServer)
+            }
+
             if (this.keyEvent.key === 'n') {
                 const currentIndex = this.serverList.findIndex(item => item.name === this.selectedServer)
                 const nextIndex = currentIndex + 1
@@ -0,0 +1,97 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"time"
+)
+
+const (
+	// How often to sample the resource usage of running servers.
+	statsSampleInterval = 2 * time.Second
+
+	// Number of samples to keep as history for each server.
+	statsHistorySize = 60
+)
+
+type ProcessStats struct {
+	CPUPercent float64 `json:"cpu_percent"`
+	MemoryRSS  uint64  `json:"memory_rss"`
+	Threads    uint    `json:"threads"`
+	OpenFiles  uint    `json:"open_files"`
+	Uptime     uint64  `json:"uptime"`
+}
+
+type ProcessSample struct {
+	Timestamp  time.Time `json:"timestamp"`
+	CPUPercent float64   `json:"cpu_percent"`
+	MemoryRSS  uint64    `json:"memory_rss"`
+}
+
+// Resource usage of a process tree at a point in time.
+type processTreeUsage struct {
+	CPUTime   time.Duration
+	MemoryRSS uint64
+	Threads   uint
+	OpenFiles uint
+}
+
+// Sample the resource usage of all running servers on an interval
+// until the program exits.
+func (runner *Runner) sampleStatsLoop(serve *Serve) {
+	for range time.Tick(statsSampleInterval) {
+		if runner.sampleStats() {
+			serve.notifyStateChange()
+		}
+	}
+}
+
+// Sample the resource usage of all running servers including their
+// descendants. Returns true if any server was sampled.
+func (runner *Runner) sampleStats() bool {
+	runner.stateMutex.Lock()
+	defer runner.stateMutex.Unlock()
+
+	sampled := false
+
+	for _, activeRunner := range runner.ActiveProcesses {
+		if activeRunner.Exited || activeRunner.Cmd == nil || activeRunner.Cmd.Process == nil {
+			continue
+		}
+
+		// The process may have exited since the check above.
+		usage, err := readProcessTreeUsage(activeRunner.Cmd.Process.Pid)
+		if err != nil {
+			continue
+		}
+
+		now := time.Now()
+		stats := ProcessStats{
+			MemoryRSS: usage.MemoryRSS,
+			Threads:   usage.Threads,
+			OpenFiles: usage.OpenFiles,
+			Uptime:    uint64(now.Sub(activeRunner.StartedAt).Seconds()),
+		}
+
+		// The CPU usage is the CPU time used since the last sample.
+		if !activeRunner.lastSampleAt.IsZero() && usage.CPUTime >= activeRunner.lastCPUTime {
+			stats.CPUPercent = float64(usage.CPUTime-activeRunner.lastCPUTime) / float64(now.Sub(activeRunner.lastSampleAt)) * 100
+		}
+
+		activeRunner.lastCPUTime = usage.CPUTime
+		activeRunner.lastSampleAt = now
+		activeRunner.Stats = &stats
+
+		activeRunner.StatsHistory = append(activeRunner.StatsHistory, ProcessSample{
+			Timestamp:  now,
+			CPUPercent: stats.CPUPercent,
+			MemoryRSS:  stats.MemoryRSS,
+		})
+
+		if len(activeRunner.StatsHistory) > statsHistorySize {
+			activeRunner.StatsHistory = activeRunner.StatsHistory[len(activeRunner.StatsHistory)-statsHistorySize:]
+		}
+
+		sampled = true
+	}
+
+	return sampled
+}
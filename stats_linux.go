@@ -0,0 +1,109 @@
+//go:build linux
+
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Clock ticks per second used in /proc, this is 100 on all
+	// architectures supported by Go.
+	procClockTicks = 100
+)
+
+// Read the resource usage of a process and all of its descendants
+// from /proc.
+func readProcessTreeUsage(pid int) (processTreeUsage, error) {
+	var usage processTreeUsage
+
+	processes, err := readProcStats()
+	if err != nil {
+		return usage, err
+	}
+
+	if _, ok := processes[pid]; !ok {
+		return usage, fmt.Errorf("process %d not found", pid)
+	}
+
+	// Find all descendants by walking the parent relationships.
+	children := make(map[int][]int)
+	for childPid, stat := range processes {
+		children[stat.ppid] = append(children[stat.ppid], childPid)
+	}
+
+	queue := []int{pid}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = append(queue[1:], children[current]...)
+
+		stat := processes[current]
+		usage.CPUTime += time.Duration(stat.cpuTicks) * time.Second / procClockTicks
+		usage.MemoryRSS += stat.rssPages * uint64(os.Getpagesize())
+		usage.Threads += stat.threads
+
+		if fds, err := os.ReadDir(filepath.Join("/proc", strconv.Itoa(current), "fd")); err == nil {
+			usage.OpenFiles += uint(len(fds))
+		}
+	}
+
+	return usage, nil
+}
+
+type procStat struct {
+	ppid     int
+	cpuTicks uint64
+	threads  uint
+	rssPages uint64
+}
+
+// Read /proc/<pid>/stat for all processes.
+func readProcStats() (map[int]procStat, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	processes := make(map[int]procStat)
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+
+		// The command name may contain spaces and parentheses, so
+		// the fields are parsed from after the last parenthesis.
+		fields := strings.Fields(string(content[strings.LastIndexByte(string(content), ')')+1:]))
+		if len(fields) < 22 {
+			continue
+		}
+
+		// Field numbers are offset by 3 since pid, comm are skipped
+		// and the slice is zero indexed.
+		ppid, _ := strconv.Atoi(fields[1])
+		utime, _ := strconv.ParseUint(fields[11], 10, 64)
+		stime, _ := strconv.ParseUint(fields[12], 10, 64)
+		threads, _ := strconv.ParseUint(fields[17], 10, 64)
+		rssPages, _ := strconv.ParseUint(fields[21], 10, 64)
+
+		processes[pid] = procStat{
+			ppid:     ppid,
+			cpuTicks: utime + stime,
+			threads:  uint(threads),
+			rssPages: rssPages,
+		}
+	}
+
+	return processes, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"fmt"
+)
+
+func readProcessTreeUsage(pid int) (processTreeUsage, error) {
+	return processTreeUsage{}, fmt.Errorf("resource usage is only supported on Linux")
+}
@@ -0,0 +1,161 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Get the paths of the certificate and key to use for TLS, if they
+// aren't configured they're stored beside the config file.
+func (config *Config) tlsFiles() (string, string) {
+	certFile := config.Settings.TLSCertFile
+	keyFile := config.Settings.TLSKeyFile
+
+	if len(certFile) == 0 && len(keyFile) == 0 {
+		base := strings.TrimSuffix(config.configFileName, filepath.Ext(config.configFileName))
+		certFile = base + ".crt"
+		keyFile = base + ".key"
+	}
+
+	return certFile, keyFile
+}
+
+// Make sure there's a certificate and key to use for TLS, a self-signed
+// certificate is generated if none is configured and none has been
+// generated before.
+func (serve *Serve) ensureCertificate() (string, string) {
+	certFile, keyFile := serve.config.tlsFiles()
+
+	if len(serve.config.Settings.TLSCertFile) == 0 && len(serve.config.Settings.TLSKeyFile) == 0 {
+		if _, err := os.Stat(certFile); os.IsNotExist(err) {
+			if err := generateCertificate(certFile, keyFile, serve.config.Settings.ListenAddress); err != nil {
+				log.Fatalf("Failed to generate self-signed certificate: %s", err)
+			}
+
+			log.Printf("Generated a self-signed certificate in %s\n", certFile)
+		}
+	}
+
+	certificate, err := readCertificate(certFile)
+	if err != nil {
+		log.Fatalf("Failed to read certificate: %s", err)
+	}
+
+	fingerprint := sha256.Sum256(certificate.Raw)
+	log.Printf("Certificate SHA-256 fingerprint: %s\n", hex.EncodeToString(fingerprint[:]))
+
+	return certFile, keyFile
+}
+
+// Generate a self-signed certificate valid for the listen address and
+// localhost, and store it with its key.
+func generateCertificate(certFile string, keyFile string, listenAddress string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "goprocmgr"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	if ip := net.ParseIP(listenAddress); ip != nil {
+		template.IPAddresses = append(template.IPAddresses, ip)
+	} else if len(listenAddress) > 0 {
+		template.DNSNames = append(template.DNSNames, listenAddress)
+	}
+
+	certificate, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	encodedKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: encodedKey}), 0600); err != nil {
+		return err
+	}
+
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), 0644)
+}
+
+// Read the first certificate from a PEM file.
+func readCertificate(certFile string) (*x509.Certificate, error) {
+	content, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in %s", certFile)
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// Build a TLS config for the CLI that only accepts the certificate of
+// the running instance, which is read from the same place as the
+// running instance reads it from.
+func (config *Config) pinnedTLSConfig() (*tls.Config, error) {
+	certFile, _ := config.tlsFiles()
+
+	certificate, err := readCertificate(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate to pin: %s", err)
+	}
+
+	return &tls.Config{
+		// The chain isn't verified since the certificate usually is
+		// self-signed, instead the certificate is compared to the
+		// pinned certificate.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], certificate.Raw) {
+				return fmt.Errorf("certificate doesn't match the pinned certificate %s", certFile)
+			}
+
+			return nil
+		},
+	}, nil
+}
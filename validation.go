@@ -0,0 +1,289 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Allowed characters in server names, since the names are used in
+// URLs, log lines and names of cgroups.
+var serverNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// A validation error of a single field in the config.
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// All validation errors of a config, this is returned as an error when
+// there is at least one validation error.
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	var messages []string
+
+	for _, err := range errs {
+		messages = append(messages, fmt.Sprintf("'%s' %s", err.Field, err.Message))
+	}
+
+	return strings.Join(messages, ", ")
+}
+
+func (errs *ValidationErrors) add(field string, format string, args ...any) {
+	*errs = append(*errs, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate a server config, the other servers of the config are used
+// to validate references to tasks.
+func (config *Config) ValidateServer(server ServerConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	if len(server.Name) == 0 {
+		errs.add("name", "cannot be empty")
+	} else if !serverNamePattern.MatchString(server.Name) {
+		errs.add("name", "can only contain letters, digits, '.', '_' and '-' and has to start with a letter or digit")
+	}
+
+	if server.Type != "" && server.Type != ServerTypeServer && server.Type != ServerTypeTask {
+		errs.add("type", "must be either '%s' or '%s'", ServerTypeServer, ServerTypeTask)
+	}
+
+	if len(server.Directory) == 0 {
+		errs.add("cwd", "cannot be empty")
+	} else if !filepath.IsAbs(server.Directory) {
+		errs.add("cwd", "has to be an absolute path")
+	} else if info, err := os.Stat(server.Directory); err != nil {
+		errs.add("cwd", "doesn't exist")
+	} else if !info.IsDir() {
+		errs.add("cwd", "isn't a directory")
+	}
+
+	if len(server.Command) == 0 {
+		errs.add("cmd", "cannot be empty")
+	} else if err := resolveCommand(server); err != nil {
+		errs.add("cmd", "%s", err)
+	}
+
+	for key, value := range server.Environment {
+		if len(key) == 0 || strings.ContainsAny(key, "=\x00") {
+			errs.add("env", "has an invalid variable name '%s'", key)
+		} else if err := config.validateEnvValue(server, value); err != nil {
+			errs.add("env", "'%s' %s", key, err)
+		} else if err := config.validateEnvPort(key, value); err != nil {
+			errs.add("env", "'%s' %s", key, err)
+		}
+	}
+
+	for _, key := range server.SecretEnv {
+		if _, ok := server.Environment[key]; !ok {
+			errs.add("secret_env", "refers to unknown variable '%s'", key)
+		}
+	}
+
+	if len(server.SecretsFile) > 0 {
+		if _, err := server.loadSecrets(); errors.Is(err, os.ErrNotExist) {
+			errs.add("secrets_file", "doesn't exist")
+		} else if err != nil {
+			errs.add("secrets_file", "%s", err)
+		}
+	}
+
+	if server.IsTask() && len(server.PreStartTasks) > 0 {
+		errs.add("pre_start_tasks", "can only be used for servers, not tasks")
+	}
+
+	for _, taskName := range server.PreStartTasks {
+		if task, ok := config.Servers[taskName]; !ok || !task.IsTask() {
+			errs.add("pre_start_tasks", "refers to unknown task '%s'", taskName)
+		}
+	}
+
+	errs = append(errs, server.validateLimits()...)
+
+	return errs
+}
+
+// Get the fixed port in an env variable such as HTTP_PORT.
+func envPort(key string, value string) (uint, bool) {
+	if !strings.HasSuffix(key, "_PORT") {
+		return 0, false
+	}
+
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, false
+	}
+
+	return uint(port), true
+}
+
+// Check that a fixed port in an env variable such as HTTP_PORT doesn't
+// collide with the ports that are given to servers or the port that
+// this program listens to. Servers using the same fixed port is only
+// a warning, see duplicateEnvPorts.
+func (config *Config) validateEnvPort(key string, value string) error {
+	port, ok := envPort(key, value)
+	if !ok {
+		return nil
+	}
+
+	settings := config.Settings
+
+	if settings.ListenNetwork == "tcp" && port == settings.ListenPort {
+		return fmt.Errorf("is the port %d that goprocmgr listens to", port)
+	}
+
+	if port >= settings.PortRangeMin && port < settings.PortRangeMax {
+		return fmt.Errorf("is the port %d inside the port range for servers, %d-%d, use ${name.PORT} to refer to the port of another server", port, settings.PortRangeMin, settings.PortRangeMax)
+	}
+
+	return nil
+}
+
+// Find the fixed ports in env variables such as HTTP_PORT that are also
+// used by other servers. These are only warnings since it's more often
+// the port of a service that the servers connect to, such as DB_PORT.
+func (config *Config) duplicateEnvPorts(server ServerConfig) []string {
+	var warnings []string
+
+	for key, value := range server.Environment {
+		port, ok := envPort(key, value)
+		if !ok {
+			continue
+		}
+
+		var users []string
+
+		for name, other := range config.Servers {
+			if name == server.Name {
+				continue
+			}
+
+			for otherKey, otherValue := range other.Environment {
+				if otherPort, ok := envPort(otherKey, otherValue); ok && otherPort == port {
+					users = append(users, name)
+					break
+				}
+			}
+		}
+
+		if len(users) > 0 {
+			sort.Strings(users)
+			warnings = append(warnings, fmt.Sprintf("'%s' is the port %d that is also used by %s", key, port, strings.Join(users, ", ")))
+		}
+	}
+
+	sort.Strings(warnings)
+
+	return warnings
+}
+
+// Check that the command of a server can be found the same way as it's
+// looked up when it's started. When using direnv the command is looked
+// up by direnv in the environment of the directory, so only direnv
+// itself has to be found. A command line run through a shell may start
+// with anything the shell understands, so only the shell has to be found.
+func resolveCommand(server ServerConfig) error {
+	name := strings.SplitN(server.Command, " ", 2)[0]
+
+	if server.UseDirenv {
+		name = "direnv"
+	} else if server.UseShell {
+		name = "sh"
+	}
+
+	if strings.Contains(name, "/") {
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(server.Directory, path)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("'%s' doesn't exist", name)
+		}
+
+		if info.IsDir() || info.Mode()&0111 == 0 {
+			return fmt.Errorf("'%s' isn't executable", name)
+		}
+
+		return nil
+	}
+
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("'%s' wasn't found in PATH", name)
+	}
+
+	return nil
+}
+
+// Validate the settings of the config.
+func (config *Config) ValidateSettings() ValidationErrors {
+	var errs ValidationErrors
+	settings := config.Settings
+
+	if settings.PortRangeMin == 0 || settings.PortRangeMin > 65535 {
+		errs.add("port_range_min", "has to be between 1 and 65535")
+	}
+
+	if settings.PortRangeMax == 0 || settings.PortRangeMax > 65535 {
+		errs.add("port_range_max", "has to be between 1 and 65535")
+	}
+
+	if settings.PortRangeMin >= settings.PortRangeMax {
+		errs.add("port_range_max", "has to be larger than 'port_range_min'")
+	}
+
+	if settings.ListenNetwork != "tcp" && settings.ListenNetwork != "unix" {
+		errs.add("listen_network", "must be either 'tcp' or 'unix'")
+	}
+
+	if settings.ListenNetwork == "tcp" {
+		if settings.ListenPort == 0 || settings.ListenPort > 65535 {
+			errs.add("listen_port", "has to be between 1 and 65535")
+		} else if settings.ListenPort >= settings.PortRangeMin && settings.ListenPort < settings.PortRangeMax {
+			errs.add("listen_port", "is inside the port range for servers, %d-%d", settings.PortRangeMin, settings.PortRangeMax)
+		}
+	}
+
+	for _, project := range settings.Projects {
+		if !filepath.IsAbs(project) {
+			errs.add("projects", "'%s' has to be an absolute path", project)
+		}
+	}
+
+	return errs
+}
+
+// Describe a JSON parse error with the line and column where it
+// happened in the content.
+func describeJSONError(content []byte, err error) string {
+	var offset int64
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+
+	if errors.As(err, &syntaxError) {
+		offset = syntaxError.Offset
+	} else if errors.As(err, &typeError) {
+		offset = typeError.Offset
+	} else {
+		return err.Error()
+	}
+
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+
+	line := 1 + strings.Count(string(content[:offset]), "\n")
+	column := int(offset) - strings.LastIndex(string(content[:offset]), "\n")
+
+	return fmt.Sprintf("line %d, column %d: %s", line, column, err)
+}
@@ -0,0 +1,160 @@
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Time to wait for more changes before acting on a change to
+	// not restart a server multiple times when many files changes.
+	watchDebounceDuration = 500 * time.Millisecond
+)
+
+// Directories that never are interesting to watch.
+var watchAlwaysIgnored = []string{".git", ".direnv"}
+
+type FileWatcher struct {
+	directory   string
+	patterns    []string
+	ignore      []string
+	onChange    func(relativePath string)
+	closer      io.Closer
+	mutex       sync.Mutex
+	timer       *time.Timer
+	lastChanged string
+}
+
+// Create a new watcher that recursively watches the directory and
+// calls onChange with the path relative to the directory of the last
+// changed file that matches any of the patterns. Multiple changes in
+// a short time are merged into one call.
+func NewFileWatcher(directory string, patterns []string, ignore []string, onChange func(relativePath string)) (*FileWatcher, error) {
+	watcher := &FileWatcher{
+		directory: directory,
+		patterns:  patterns,
+		ignore:    append(append([]string{}, watchAlwaysIgnored...), ignore...),
+		onChange:  onChange,
+	}
+
+	if err := watcher.watch(); err != nil {
+		return nil, err
+	}
+
+	return watcher, nil
+}
+
+// Stop watching for changes.
+func (watcher *FileWatcher) Close() error {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	if watcher.timer != nil {
+		watcher.timer.Stop()
+	}
+
+	return watcher.closer.Close()
+}
+
+// Handle a change to a file, called by the platform implementation.
+func (watcher *FileWatcher) changed(fullPath string) {
+	relativePath, err := filepath.Rel(watcher.directory, fullPath)
+	if err != nil {
+		return
+	}
+
+	relativePath = filepath.ToSlash(relativePath)
+
+	if watcher.isIgnored(relativePath) || !watcher.isWatched(relativePath) {
+		return
+	}
+
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	watcher.lastChanged = relativePath
+
+	if watcher.timer != nil {
+		watcher.timer.Stop()
+	}
+
+	watcher.timer = time.AfterFunc(watchDebounceDuration, func() {
+		watcher.mutex.Lock()
+		lastChanged := watcher.lastChanged
+		watcher.mutex.Unlock()
+
+		watcher.onChange(lastChanged)
+	})
+}
+
+// Check if a path relative to the watched directory matches any of
+// the watch patterns.
+func (watcher *FileWatcher) isWatched(relativePath string) bool {
+	for _, pattern := range watcher.patterns {
+		if matchGlob(pattern, relativePath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check if a path relative to the watched directory, or any of its
+// parent directories, matches any of the ignore patterns.
+func (watcher *FileWatcher) isIgnored(relativePath string) bool {
+	segments := strings.Split(relativePath, "/")
+
+	for _, pattern := range watcher.ignore {
+		for idx := range segments {
+			if matchGlob(pattern, strings.Join(segments[:idx+1], "/")) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Match a slash separated path against a glob pattern where "**"
+// matches any number of directories. Patterns without a slash are
+// matched against the base name of the path.
+func matchGlob(pattern string, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(name))
+
+		return matched
+	}
+
+	return matchGlobSegments(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+func matchGlobSegments(patternSegments []string, nameSegments []string) bool {
+	if len(patternSegments) == 0 {
+		return len(nameSegments) == 0
+	}
+
+	if patternSegments[0] == "**" {
+		// Try to let "**" consume zero or more segments.
+		for idx := 0; idx <= len(nameSegments); idx++ {
+			if matchGlobSegments(patternSegments[1:], nameSegments[idx:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(nameSegments) == 0 {
+		return false
+	}
+
+	if matched, _ := path.Match(patternSegments[0], nameSegments[0]); !matched {
+		return false
+	}
+
+	return matchGlobSegments(patternSegments[1:], nameSegments[1:])
+}
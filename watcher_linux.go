@@ -0,0 +1,100 @@
+//go:build linux
+
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyWatchMask = syscall.IN_CLOSE_WRITE |
+	syscall.IN_CREATE |
+	syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO
+
+// Set up inotify watches for the directory and all directories below
+// it that aren't ignored, and start reading the events.
+func (watcher *FileWatcher) watch() error {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("failed to initialize inotify: %s", err)
+	}
+
+	// Wrap the non blocking file descriptor in a file to let the
+	// runtime poll it and to be able to interrupt reads by closing.
+	file := os.NewFile(uintptr(fd), "inotify")
+	watcher.closer = file
+
+	var directoriesMutex sync.Mutex
+	directories := make(map[int32]string)
+
+	addDirectory := func(directory string) {
+		filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || !entry.IsDir() {
+				return nil
+			}
+
+			if relativePath, err := filepath.Rel(watcher.directory, path); err == nil && relativePath != "." && watcher.isIgnored(filepath.ToSlash(relativePath)) {
+				return filepath.SkipDir
+			}
+
+			wd, err := syscall.InotifyAddWatch(fd, path, inotifyWatchMask)
+			if err != nil {
+				log.Printf("Failed to watch directory %s: %s", path, err)
+				return nil
+			}
+
+			directoriesMutex.Lock()
+			directories[int32(wd)] = path
+			directoriesMutex.Unlock()
+
+			return nil
+		})
+	}
+
+	addDirectory(watcher.directory)
+
+	go func() {
+		buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+
+		for {
+			count, err := file.Read(buffer)
+			if err != nil {
+				return
+			}
+
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+				nameBytes := buffer[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+				offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+				directoriesMutex.Lock()
+				directory, ok := directories[event.Wd]
+				directoriesMutex.Unlock()
+
+				if !ok {
+					continue
+				}
+
+				path := filepath.Join(directory, strings.TrimRight(string(nameBytes), "\x00"))
+
+				// Start watching new directories as well.
+				if event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					addDirectory(path)
+				}
+
+				watcher.changed(path)
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main // import "github.com/etu/goprocmgr"
+
+import (
+	"fmt"
+)
+
+func (watcher *FileWatcher) watch() error {
+	return fmt.Errorf("file watching is only supported on Linux")
+}
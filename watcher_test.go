@@ -0,0 +1,55 @@
+package main // import "github.com/etu/goprocmgr"
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{pattern: "*.go", name: "main.go", expected: true},
+		{pattern: "*.go", name: "cmd/server/main.go", expected: true},
+		{pattern: "*.go", name: "main.go.orig", expected: false},
+		{pattern: "src/*.js", name: "src/app.js", expected: true},
+		{pattern: "src/*.js", name: "src/lib/app.js", expected: false},
+		{pattern: "/src/*.js", name: "src/app.js", expected: true},
+		{pattern: "src/**/*.js", name: "src/app.js", expected: true},
+		{pattern: "src/**/*.js", name: "src/lib/deep/app.js", expected: true},
+		{pattern: "src/**/*.js", name: "lib/app.js", expected: false},
+		{pattern: "**/node_modules", name: "node_modules", expected: true},
+		{pattern: "**/node_modules", name: "web/node_modules", expected: true},
+		{pattern: "src/**", name: "src/lib/app.js", expected: true},
+		{pattern: "src/**", name: "src", expected: true},
+		{pattern: "a/?.txt", name: "a/b.txt", expected: true},
+		{pattern: "a/?.txt", name: "a/bc.txt", expected: false},
+	}
+
+	for _, test := range tests {
+		if matched := matchGlob(test.pattern, test.name); matched != test.expected {
+			t.Errorf("matchGlob(%q, %q) = %t, expected %t", test.pattern, test.name, matched, test.expected)
+		}
+	}
+}
+
+func TestFileWatcherIsIgnored(t *testing.T) {
+	watcher := &FileWatcher{ignore: []string{"node_modules", ".git", "build/**/*.o"}}
+
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{name: "node_modules/pkg/index.js", expected: true},
+		{name: "web/node_modules/pkg/index.js", expected: true},
+		{name: ".git/HEAD", expected: true},
+		{name: "build/obj/main.o", expected: true},
+		{name: "build/main.c", expected: false},
+		{name: "src/main.go", expected: false},
+	}
+
+	for _, test := range tests {
+		if ignored := watcher.isIgnored(test.name); ignored != test.expected {
+			t.Errorf("isIgnored(%q) = %t, expected %t", test.name, ignored, test.expected)
+		}
+	}
+}